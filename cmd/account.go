@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/internal/config"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/internal/metric"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena"
+	"github.com/prometheus/client_golang/prometheus"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// envSuffix returns the suffix of the environment variables of the account's credentials, e.g.
+// _BACK_YARD for the account back-yard. The default account has none.
+func envSuffix(a config.Account) string {
+	if a.Name == config.DefaultAccount {
+		return ""
+	}
+	return "_" + strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, a.Name)
+}
+
+// credentialProvider returns the credential provider of the account's credential source, the
+// account has to be validated by config.Config.Validate
+func credentialProvider(a config.Account) gardena.CredentialProvider {
+	switch a.CredentialSource {
+	case config.SourceEnv:
+		return gardena.EnvProvider{
+			ClientIDVar:     gardena.DefaultClientIDEnv + envSuffix(a),
+			ClientSecretVar: gardena.DefaultClientSecretEnv + envSuffix(a),
+		}
+	case config.SourceFile:
+		return gardena.CredentialsFileProvider{Path: a.CredentialsFile}
+	case config.SourceCommand:
+		name, _, _ := strings.Cut(strings.TrimSpace(a.CredentialsCommand), " ")
+		return gardena.CommandProvider{Command: []string{"/bin/sh", "-c", a.CredentialsCommand}, Name: name}
+	default:
+		return gardena.SecretFilesProvider{Dir: a.SecretFilePath}
+	}
+}
+
+const (
+	// accountRetryInterval is the time before a failed account is started again, it doubles with
+	// every failure up to maxAccountRetryInterval
+	accountRetryInterval    = time.Minute
+	maxAccountRetryInterval = 30 * time.Minute
+)
+
+// run starts the account like start and retries in the background until it started or the given
+// context is canceled. The given function is called with the api and generator once it started.
+func run(ctx context.Context, a config.Account, c config.Config, started func(*gardena.API, *metric.Generator)) {
+	wait := accountRetryInterval
+	for {
+		api, g, err := start(ctx, a, c)
+		metric.AccountStarted(a.Name, err)
+		if err == nil {
+			log.Printf("Started account %s", a.Name)
+			started(api, g)
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Unable to start account %s, retrying in %v, got err:\n%v", a.Name, wait, err)
+		if !sleep(ctx, wait) {
+			return
+		}
+		if wait *= 2; wait > maxAccountRetryInterval {
+			wait = maxAccountRetryInterval
+		}
+	}
+}
+
+// start initializes the api and metric generator of the account and starts refreshing its devices
+// in the background until the given context is canceled
+func start(ctx context.Context, a config.Account, c config.Config) (*gardena.API, *metric.Generator, error) {
+	builder := gardena.NewAPI().WithCredentialProvider(credentialProvider(a))
+	if c.APIURL != "" {
+		builder.WithBaseURL(c.APIURL)
+	}
+	if c.AuthURL != "" {
+		builder.WithAuthURL(c.AuthURL)
+	}
+	if c.DailyRequestBudget > 0 {
+		builder.WithRequestBudget(c.DailyRequestBudget, 24*time.Hour)
+	}
+	if c.WeeklyRequestBudget > 0 {
+		builder.WithRequestBudget(c.WeeklyRequestBudget, 7*24*time.Hour)
+	}
+	if c.Record != "" {
+		builder.WithRecording(c.Record)
+	}
+	if c.Replay != "" {
+		builder.WithReplay(c.Replay)
+	}
+	api, err := builder.InitializeWithContext(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to initialize the api, got error:\n%w", err)
+	}
+
+	g := metric.NewAccountGenerator(a.Name, api, a.GatewayIP).WithOptions(metric.Options{
+		DeviceTypes:       c.DeviceTypes,
+		DisableTimestamps: !c.MetricTimestamps,
+	})
+	if err := g.Register(prometheus.DefaultRegisterer); err != nil {
+		api.Close()
+		return nil, nil, fmt.Errorf("unable to register device metrics, got err:\n%w", err)
+	}
+	if err := g.InitializeLocationsMetrics(ctx); err != nil {
+		// the periodic refresh retries
+		log.Printf("Unable to setup initial location metrics of account %s, got err:\n%v", a.Name, err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		if err := api.Close(); err != nil {
+			log.Printf("Unable to close recording of account %s, got err:\n%v", a.Name, err)
+		}
+	}()
+	go func() {
+		if err := api.RunTokenRefresh(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Stopped refreshing the access token of account %s, got err:\n%v", a.Name, err)
+		}
+	}()
+	realtime := c.Realtime
+	if c.Replay != "" {
+		log.Println("Replaying recorded requests, health checks, credential reloads and realtime updates are disabled")
+		realtime = false
+	} else {
+		if c.CredentialReloadInterval > 0 {
+			go func() {
+				if err := api.RunCredentialReload(ctx, time.Duration(c.CredentialReloadInterval)*time.Second); err != nil && ctx.Err() == nil {
+					log.Printf("Stopped reloading credentials of account %s, got err:\n%v", a.Name, err)
+				}
+			}()
+		}
+		go func() {
+			for {
+				g.MonitorHealthOfEndpoints(ctx)
+				if !sleep(ctx, time.Duration(c.MetricInterval)*time.Second) {
+					return
+				}
+			}
+		}()
+	}
+	if realtime {
+		// retries until the locations are known, the periodic refresh keeps the subscriptions in sync
+		go g.RunRealtime(ctx)
+	}
+	go func() {
+		for sleep(ctx, time.Duration(c.StateInterval)*time.Second) {
+			if err := g.RefreshState(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Unable to refresh device states of account %s, got err:\n%v", a.Name, err)
+			}
+		}
+	}()
+	return api, g, nil
+}
@@ -0,0 +1,43 @@
+// gardena-fake-cloud runs a fake Gardena cloud serving fixture files, to run the exporter offline:
+//
+//	go run ./cmd/gardena-fake-cloud -fixtures test/location.json,test/location_valves.json
+//	GARDENA_CLIENT_ID=fake GARDENA_CLIENT_SECRET=fake go run ./cmd -api-url http://localhost:8090/v1 \
+//		-auth-url http://localhost:8090/oauth2/token -credential-source env
+//
+// The fake cloud accepts any client-id and client-secret, they only must not be empty.
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena/gardenatest"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+func main() {
+	var addr string
+	var fixtures string
+	flag.StringVar(&addr, "listen", "localhost:8090", "The address the fake cloud listens on")
+	flag.StringVar(&fixtures, "fixtures", "test/location.json", "Comma separated list of location fixtures to serve")
+	flag.Parse()
+
+	s, err := gardenatest.NewServerAt(addr)
+	if err != nil {
+		log.Fatalf("Unable to start fake cloud, got err:\n%v", err)
+	}
+	defer s.Close()
+	for _, f := range strings.Split(fixtures, ",") {
+		if err := s.LoadFixture(strings.TrimSpace(f)); err != nil {
+			log.Fatalf("Unable to load fixture, got err:\n%v", err)
+		}
+	}
+	log.Printf("Serving fake gardena api at %s, authentication at %s", s.APIURL(), s.AuthURL())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+}
@@ -1,45 +1,59 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
-	"fmt"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/internal/config"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/internal/control"
 	"github.com/Christoph-Raab/gardena-smart-system-exporter/internal/metric"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/internal/web"
 	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
 func main() {
-	var gatewayIP string
-	var metricInterval int
-	var secretFilePath string
-	flag.StringVar(&gatewayIP, "gateway-ip", metric.EmptyGatewayIP, "Ip of the Smart System Gateway Bridge Device, e.g. 192.168.178.24")
-	flag.IntVar(&metricInterval, "metric-interval", 30, "Time between each metric generation run in seconds")
-	flag.StringVar(&secretFilePath, "secret-file-path", "/etc/secrets/gardena-smart-system-exporter", "The path where client-id and client-secret files are stored.")
-	flag.Parse()
-
-	api, err := gardena.NewAPI().
-		WithSecretFilePath(secretFilePath).
-		Initialize()
+	c, err := config.Load(os.Args[0], os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
 	if err != nil {
-		log.Fatalf("unable to initialize the api, got error:\n%v", err)
+		log.Fatalf("Invalid configuration, got err:\n%v", err)
 	}
+	accounts := c.AccountsWithDefaults()
 
-	g := metric.NewGenerator(*api, gatewayIP)
-	if err := g.InitializeLocationsMetrics(); err != nil {
-		log.Fatalf("Unable to setup initial location metrics, got err:\n%v", err)
-	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	log.Println("Start serving metrics...")
-	go func() {
-		for {
-			g.MonitorHealthOfEndpoints()
-			time.Sleep(time.Duration(metricInterval) * time.Second)
+	var handler *control.Handler
+	if c.ControlTokenFile != "" {
+		token, err := os.ReadFile(c.ControlTokenFile)
+		if err != nil {
+			log.Fatalf("Unable to read token of control api, got err:\n%v", err)
 		}
-	}()
+		handler, err = control.NewHandler(strings.TrimSpace(string(token)))
+		if err != nil {
+			log.Fatalf("Unable to setup control api, got err:\n%v", err)
+		}
+	}
+	// accounts failing to start are retried in the background, so they don't stop the others
+	for _, a := range accounts {
+		log.Printf("Starting account %s", a.Name)
+		go run(ctx, a, c, func(api *gardena.API, g *metric.Generator) {
+			if handler != nil {
+				handler.AddAccount(api, g.Store())
+			}
+		})
+	}
 
+	log.Println("Start serving metrics...")
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
 			<head><title>Gardena Smart System Exporter</title></head>
@@ -51,5 +65,35 @@ func main() {
 			</html>`))
 	})
 	http.Handle("/metrics", promhttp.Handler())
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", 9093), nil))
+	if handler != nil {
+		http.Handle(control.PathPrefix, handler)
+		log.Println("Control api enabled")
+	}
+
+	srv := &http.Server{Addr: c.ListenAddress}
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		log.Println("Shutting down...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Unable to shut down http server gracefully, got err:\n%v", err)
+		}
+	}()
+	if err := web.ListenAndServe(srv, c.WebConfigFile, control.PathPrefix); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-shutdown
+}
+
+// sleep waits for the given duration and reports false if the context was canceled in the meantime
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
 }
@@ -0,0 +1,461 @@
+package config
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena/device"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding the config file, e.g.
+// GARDENA_EXPORTER_STATE_INTERVAL overrides state_interval
+const EnvPrefix = "GARDENA_EXPORTER_"
+
+// DefaultAccount is the name of the account used if no accounts are configured, it's the same
+// as metric.DefaultAccount
+const DefaultAccount = "default"
+
+// EmptyGatewayIP disables the health check of the gateway, it's the same as metric.EmptyGatewayIP
+const EmptyGatewayIP = "None"
+
+// Credential sources of an account
+const (
+	SourceFiles   = "files"
+	SourceEnv     = "env"
+	SourceFile    = "file"
+	SourceCommand = "command"
+)
+
+// Config is the configuration of the exporter. The keys of the config file are the names of the
+// flags with underscores instead of dashes.
+type Config struct {
+	ListenAddress            string    `yaml:"listen_address"`
+	WebConfigFile            string    `yaml:"web_config_file"`
+	APIURL                   string    `yaml:"api_url"`
+	AuthURL                  string    `yaml:"auth_url"`
+	GatewayIP                string    `yaml:"gateway_ip"`
+	MetricInterval           int       `yaml:"metric_interval"`
+	StateInterval            int       `yaml:"state_interval"`
+	Realtime                 bool      `yaml:"realtime"`
+	CredentialSource         string    `yaml:"credential_source"`
+	SecretFilePath           string    `yaml:"secret_file_path"`
+	CredentialsFile          string    `yaml:"credentials_file"`
+	CredentialsCommand       string    `yaml:"credentials_command"`
+	CredentialReloadInterval int       `yaml:"credential_reload_interval"`
+	Accounts                 []Account `yaml:"accounts"`
+	ControlTokenFile         string    `yaml:"control_token_file"`
+	DailyRequestBudget       int       `yaml:"daily_request_budget"`
+	WeeklyRequestBudget      int       `yaml:"weekly_request_budget"`
+	Record                   string    `yaml:"record"`
+	Replay                   string    `yaml:"replay"`
+	DeviceTypes              []string  `yaml:"device_types"`
+	MetricTimestamps         bool      `yaml:"metric_timestamps"`
+}
+
+// Account is a husqvarna account monitored by the exporter. Unset values are taken from the
+// values of the same name of the Config.
+type Account struct {
+	Name               string `yaml:"name"`
+	GatewayIP          string `yaml:"gateway_ip"`
+	CredentialSource   string `yaml:"credential_source"`
+	SecretFilePath     string `yaml:"secret_file_path"`
+	CredentialsFile    string `yaml:"credentials_file"`
+	CredentialsCommand string `yaml:"credentials_command"`
+}
+
+// Default returns the default configuration
+func Default() Config {
+	return Config{
+		ListenAddress:            ":9093",
+		GatewayIP:                EmptyGatewayIP,
+		MetricInterval:           30,
+		StateInterval:            300,
+		CredentialSource:         SourceFiles,
+		SecretFilePath:           "/etc/secrets/gardena-smart-system-exporter",
+		CredentialReloadInterval: 60,
+		MetricTimestamps:         true,
+	}
+}
+
+// setting is a value of the Config, set by a flag, an environment variable or the config file
+type setting struct {
+	// name is the name of the flag, the environment variable is derived from it
+	name  string
+	usage string
+	// field returns a pointer to the value in the given config
+	field func(c *Config) any
+}
+
+var settings = []setting{
+	{"listen-address", "The address the exporter listens on", func(c *Config) any { return &c.ListenAddress }},
+	{"web-config-file", "The path of a web config file enabling TLS and basic auth, see the README. Changes of the file take effect without restart.", func(c *Config) any { return &c.WebConfigFile }},
+	{"api-url", "Base url of the gardena api, e.g. of a fake cloud for development. Defaults to the gardena smart system api.", func(c *Config) any { return &c.APIURL }},
+	{"auth-url", "Url of the token endpoint used to authenticate. Defaults to the husqvarna authentication api.", func(c *Config) any { return &c.AuthURL }},
+	{"gateway-ip", "Ip of the Smart System Gateway Bridge Device, e.g. 192.168.178.24", func(c *Config) any { return &c.GatewayIP }},
+	{"metric-interval", "Time between each metric generation run in seconds", func(c *Config) any { return &c.MetricInterval }},
+	{"state-interval", "Time between each refresh of the device states in seconds", func(c *Config) any { return &c.StateInterval }},
+	{"realtime", "Receive device updates from the realtime websocket api in addition to the periodic refresh", func(c *Config) any { return &c.Realtime }},
+	{"credential-source", "Source of client-id and client-secret, one of 'files' (secret-file-path), 'env' (GARDENA_CLIENT_ID and GARDENA_CLIENT_SECRET), 'file' (credentials-file) or 'command' (credentials-command)", func(c *Config) any { return &c.CredentialSource }},
+	{"secret-file-path", "The path where client-id and client-secret files are stored.", func(c *Config) any { return &c.SecretFilePath }},
+	{"credentials-file", "The path of a JSON or YAML file with client_id and client_secret, used by the credential source 'file'", func(c *Config) any { return &c.CredentialsFile }},
+	{"credentials-command", "A shell command printing client_id and client_secret as JSON or YAML, used by the credential source 'command'", func(c *Config) any { return &c.CredentialsCommand }},
+	{"credential-reload-interval", "Time between each check of the credential source for rotated credentials in seconds, 0 disables reloading. The credential source 'command' runs its command for every check.", func(c *Config) any { return &c.CredentialReloadInterval }},
+	{"account", "An account to monitor as comma separated key=value pairs, e.g. 'name=garden,credential-source=file,credentials-file=/etc/garden.yaml'. Besides name, the keys gateway-ip, credential-source, secret-file-path, credentials-file and credentials-command override the flags of the same name. Pairs containing commas have to be quoted, e.g. '\"credentials-command=pass show garden,json\"'. Can be repeated, the environment variable separates accounts by ';'. Defaults to a single account named 'default'.", func(c *Config) any { return &c.Accounts }},
+	{"control-token-file", "The path of a file containing the bearer token of the control api. The control api is disabled if not set.", func(c *Config) any { return &c.ControlTokenFile }},
+	{"daily-request-budget", "Max number of requests sent to the gardena api per day and account, 0 disables the budget", func(c *Config) any { return &c.DailyRequestBudget }},
+	{"weekly-request-budget", "Max number of requests sent to the gardena api per week and account, 0 disables the budget", func(c *Config) any { return &c.WeeklyRequestBudget }},
+	{"record", "Append all api requests and responses to the given file, with credentials and serials redacted", func(c *Config) any { return &c.Record }},
+	{"replay", "Answer all api requests with the responses of the given recording instead of querying the api", func(c *Config) any { return &c.Replay }},
+	{"device-types", "Comma separated types of the devices to export, e.g. MOWER,SENSOR. All devices are exported if empty.", func(c *Config) any { return &c.DeviceTypes }},
+	{"metric-timestamps", "Export the time the attributes of devices were last updated", func(c *Config) any { return &c.MetricTimestamps }},
+}
+
+// env returns the name of the environment variable of the setting
+func (s setting) env() string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(s.name, "-", "_"))
+}
+
+// set parses the given values and sets them in the given config. The values replace accounts of
+// previous sources, for all other settings the last value is taken.
+func (s setting) set(c *Config, values []string) error {
+	if p, ok := s.field(c).(*[]Account); ok {
+		accounts := make([]Account, 0, len(values))
+		for i, v := range values {
+			// the value isn't reported, since it might contain a credentials command
+			a, err := parseAccount(v)
+			if err != nil && a.Name != "" {
+				return fmt.Errorf("%s: invalid account %s, got err: %w", s.name, a.Name, err)
+			}
+			if err != nil {
+				return fmt.Errorf("%s: invalid account #%d, got err: %w", s.name, i+1, err)
+			}
+			accounts = append(accounts, a)
+		}
+		*p = accounts
+		return nil
+	}
+	for _, v := range values {
+		var err error
+		switch p := s.field(c).(type) {
+		case *string:
+			*p = v
+		case *int:
+			*p, err = strconv.Atoi(v)
+		case *bool:
+			*p, err = strconv.ParseBool(v)
+		case *[]string:
+			*p = splitList(v, ",")
+		}
+		if err != nil {
+			return fmt.Errorf("%s: invalid value '%s'", s.name, v)
+		}
+	}
+	return nil
+}
+
+// defaultOf returns the value of the setting in the given config, as shown in the usage of its flag
+func (s setting) defaultOf(c *Config) string {
+	switch p := s.field(c).(type) {
+	case *[]string:
+		return strings.Join(*p, ",")
+	case *[]Account:
+		return ""
+	case *string:
+		return *p
+	case *int:
+		return strconv.Itoa(*p)
+	case *bool:
+		if !*p {
+			// like flag.Bool, false isn't shown as default
+			return ""
+		}
+		return strconv.FormatBool(*p)
+	}
+	return ""
+}
+
+// flagValue is a flag.Value collecting the values of a flag, they are applied after the config
+// file and the environment
+type flagValue struct {
+	def    string
+	isBool bool
+	values []string
+}
+
+func (v *flagValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return v.def
+}
+
+func (v *flagValue) Set(s string) error {
+	v.values = append(v.values, s)
+	return nil
+}
+
+// IsBoolFlag allows boolean flags to be set without value, e.g. -realtime
+func (v *flagValue) IsBoolFlag() bool {
+	return v.isBool
+}
+
+// Load loads the configuration from the given command line arguments, the environment and the
+// config file set by the config flag or the GARDENA_EXPORTER_CONFIG environment variable.
+// Values are taken in the order of precedence: flags, environment variables, config file, defaults.
+// All invalid values are reported at once. If the arguments ask for help, flag.ErrHelp is returned.
+func Load(name string, args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	c := Default()
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	path := fs.String("config", "", "The path of a YAML config file, its keys are the names of the flags with underscores, e.g. state_interval")
+	flags := make([]*flagValue, len(settings))
+	for i, s := range settings {
+		_, isBool := s.field(&c).(*bool)
+		flags[i] = &flagValue{def: s.defaultOf(&c), isBool: isBool}
+		fs.Var(flags[i], s.name, s.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	if fs.NArg() > 0 {
+		return Config{}, fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+
+	if *path == "" {
+		*path, _ = lookupEnv(EnvPrefix + "CONFIG")
+	}
+	// invalid values are collected, so they are reported together with the validation errors
+	var errs []error
+	if *path != "" {
+		if err := c.readFile(*path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, s := range settings {
+		v, ok := lookupEnv(s.env())
+		if !ok {
+			continue
+		}
+		values := []string{v}
+		if _, ok := s.field(&c).(*[]Account); ok {
+			values = splitList(v, ";")
+		}
+		if err := s.set(&c, values); err != nil {
+			errs = append(errs, fmt.Errorf("environment variable %s: %w", s.env(), err))
+		}
+	}
+	for i, s := range settings {
+		if len(flags[i].values) == 0 {
+			continue
+		}
+		if err := s.set(&c, flags[i].values); err != nil {
+			errs = append(errs, fmt.Errorf("flag %w", err))
+		}
+	}
+	if err := c.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return Config{}, errors.Join(errs...)
+	}
+	return c, nil
+}
+
+// readFile reads the YAML config file at the given path into the config. Unknown keys are rejected,
+// so typos don't go unnoticed.
+func (c *Config) readFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read config file, got err:\n%w", err)
+	}
+	d := yaml.NewDecoder(bytes.NewReader(b))
+	d.KnownFields(true)
+	if err := d.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s, got err:\n%w", path, err)
+	}
+	return nil
+}
+
+// AccountsWithDefaults returns the configured accounts with their unset values taken from the
+// config. Without accounts, a single account named DefaultAccount is returned.
+func (c Config) AccountsWithDefaults() []Account {
+	accounts := c.Accounts
+	if len(accounts) == 0 {
+		accounts = []Account{{Name: DefaultAccount}}
+	}
+	result := make([]Account, 0, len(accounts))
+	for _, a := range accounts {
+		set := func(v *string, def string) {
+			if *v == "" {
+				*v = def
+			}
+		}
+		set(&a.GatewayIP, c.GatewayIP)
+		set(&a.CredentialSource, c.CredentialSource)
+		set(&a.SecretFilePath, c.SecretFilePath)
+		set(&a.CredentialsFile, c.CredentialsFile)
+		set(&a.CredentialsCommand, c.CredentialsCommand)
+		result = append(result, a)
+	}
+	return result
+}
+
+// Validate checks the config and reports all invalid values at once
+func (c Config) Validate() error {
+	var errs []error
+	fail := func(name, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]any{name}, args...)...))
+	}
+
+	if _, _, err := net.SplitHostPort(c.ListenAddress); err != nil {
+		fail("listen-address", "expected host:port, got '%s'", c.ListenAddress)
+	}
+	for _, u := range []struct{ name, url string }{{"api-url", c.APIURL}, {"auth-url", c.AuthURL}} {
+		if u.url == "" {
+			continue
+		}
+		if p, err := url.Parse(u.url); err != nil || (p.Scheme != "http" && p.Scheme != "https") || p.Host == "" {
+			fail(u.name, "expected http or https url, got '%s'", u.url)
+		}
+	}
+	for _, i := range []struct {
+		name     string
+		v        int
+		positive bool
+	}{
+		{"metric-interval", c.MetricInterval, true},
+		{"state-interval", c.StateInterval, true},
+		{"credential-reload-interval", c.CredentialReloadInterval, false},
+		{"daily-request-budget", c.DailyRequestBudget, false},
+		{"weekly-request-budget", c.WeeklyRequestBudget, false},
+	} {
+		switch {
+		case i.positive && i.v <= 0:
+			fail(i.name, "has to be positive, got %d", i.v)
+		case i.v < 0:
+			fail(i.name, "can not be negative, got %d", i.v)
+		}
+	}
+	for _, t := range c.DeviceTypes {
+		if !contains(device.Types, t) {
+			fail("device-types", "unknown device type '%s', expected one of %s", t, strings.Join(device.Types, ", "))
+		}
+	}
+
+	accounts := c.AccountsWithDefaults()
+	if c.Record != "" && c.Replay != "" {
+		fail("record", "recording and replaying can not be combined")
+	}
+	if len(accounts) > 1 && (c.Record != "" || c.Replay != "") {
+		fail("account", "recording and replaying support a single account only, got %d accounts", len(accounts))
+	}
+	names := map[string]bool{}
+	for _, a := range accounts {
+		switch {
+		case a.Name == "":
+			fail("account", "name is required")
+		case names[a.Name]:
+			fail("account", "duplicate account %s", a.Name)
+		}
+		names[a.Name] = true
+		errs = append(errs, a.validate()...)
+	}
+	return errors.Join(errs...)
+}
+
+// validate checks the gateway and credential source of the account
+func (a Account) validate() []error {
+	var errs []error
+	fail := func(name, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("account %s: %s: "+format, append([]any{a.Name, name}, args...)...))
+	}
+	if a.GatewayIP != EmptyGatewayIP && net.ParseIP(a.GatewayIP) == nil {
+		fail("gateway-ip", "expected ip address or %s, got '%s'", EmptyGatewayIP, a.GatewayIP)
+	}
+	switch a.CredentialSource {
+	case SourceFiles, SourceEnv:
+	case SourceFile:
+		if a.CredentialsFile == "" {
+			fail("credentials-file", "required for the credential source '%s'", SourceFile)
+		}
+	case SourceCommand:
+		if a.CredentialsCommand == "" {
+			fail("credentials-command", "required for the credential source '%s'", SourceCommand)
+		}
+	default:
+		fail("credential-source", "unknown credential source '%s', expected one of files, env, file or command", a.CredentialSource)
+	}
+	return errs
+}
+
+// parseAccount parses the comma separated key=value pairs of an account. The keys are named like
+// the flags they override for the account. Pairs containing commas have to be quoted.
+func parseAccount(v string) (Account, error) {
+	// pairs are split like CSV fields, so a pair containing commas can be quoted as a whole,
+	// e.g. "credentials-command=pass show garden,json"
+	r := csv.NewReader(strings.NewReader(v))
+	r.LazyQuotes = true
+	r.TrimLeadingSpace = true
+	pairs, err := r.Read()
+	if err != nil {
+		return Account{}, fmt.Errorf("unable to split key=value pairs, got err: %w", err)
+	}
+	var a Account
+	var errs []error
+	for i, pair := range pairs {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			errs = append(errs, fmt.Errorf("expected key=value, got pair %d without '='", i+1))
+			continue
+		}
+		switch key {
+		case "name":
+			a.Name = value
+		case "gateway-ip":
+			a.GatewayIP = value
+		case "credential-source":
+			a.CredentialSource = value
+		case "secret-file-path":
+			a.SecretFilePath = value
+		case "credentials-file":
+			a.CredentialsFile = value
+		case "credentials-command":
+			a.CredentialsCommand = value
+		default:
+			errs = append(errs, fmt.Errorf("unknown key '%s'", key))
+		}
+	}
+	if len(errs) > 0 {
+		// only the name is returned, to report which account is invalid
+		return Account{Name: a.Name}, errors.Join(errs...)
+	}
+	return a, nil
+}
+
+// splitList splits a list by the given separator, dropping empty elements
+func splitList(v, sep string) []string {
+	var list []string
+	for _, e := range strings.Split(v, sep) {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+func contains(list []string, v string) bool {
+	for _, e := range list {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/internal/metric"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadDefaults(t *testing.T) {
+	c, err := Load("test", nil, env(nil))
+	if err != nil {
+		t.Fatalf("Unable to load config, got err:\n%v", err)
+	}
+	if !reflect.DeepEqual(c, Default()) {
+		t.Fatalf("Expected default config %+v, got %+v", Default(), c)
+	}
+	expected := []Account{{
+		Name:             DefaultAccount,
+		GatewayIP:        EmptyGatewayIP,
+		CredentialSource: SourceFiles,
+		SecretFilePath:   "/etc/secrets/gardena-smart-system-exporter",
+	}}
+	if accounts := c.AccountsWithDefaults(); !reflect.DeepEqual(accounts, expected) {
+		t.Fatalf("Expected accounts %+v, got %+v", expected, accounts)
+	}
+	if DefaultAccount != metric.DefaultAccount || EmptyGatewayIP != metric.EmptyGatewayIP {
+		t.Fatalf("Expected defaults of the metric package, got %s and %s", DefaultAccount, EmptyGatewayIP)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+listen_address: ":9100"
+metric_interval: 10
+state_interval: 100
+realtime: true
+gateway_ip: 192.168.178.24
+accounts:
+  - name: garden
+  - name: back-yard
+    credential_source: env
+`)
+	c, err := Load("test", []string{"-config", path, "-state-interval", "400", "-metric-timestamps=false"}, env(map[string]string{
+		"GARDENA_EXPORTER_STATE_INTERVAL":  "200",
+		"GARDENA_EXPORTER_METRIC_INTERVAL": "20",
+		"GARDENA_EXPORTER_DEVICE_TYPES":    "MOWER, VALVE",
+	}))
+	if err != nil {
+		t.Fatalf("Unable to load config, got err:\n%v", err)
+	}
+
+	// flags override the environment, which overrides the config file
+	if c.StateInterval != 400 || c.MetricInterval != 20 || c.ListenAddress != ":9100" || !c.Realtime || c.MetricTimestamps {
+		t.Fatalf("Unexpected config %+v", c)
+	}
+	if !reflect.DeepEqual(c.DeviceTypes, []string{"MOWER", "VALVE"}) {
+		t.Fatalf("Expected device types of the environment, got %v", c.DeviceTypes)
+	}
+	accounts := c.AccountsWithDefaults()
+	if len(accounts) != 2 || accounts[0].Name != "garden" || accounts[1].CredentialSource != SourceEnv || accounts[1].GatewayIP != "192.168.178.24" {
+		t.Fatalf("Expected accounts of the config file with defaults, got %+v", accounts)
+	}
+}
+
+func TestLoadExample(t *testing.T) {
+	c, err := Load("test", []string{"-config", "../../configs/config.example.yaml"}, env(nil))
+	if err != nil {
+		t.Fatalf("Unable to load example config, got err:\n%v", err)
+	}
+	if len(c.AccountsWithDefaults()) != 2 {
+		t.Fatalf("Expected 2 accounts, got %+v", c.AccountsWithDefaults())
+	}
+}
+
+func TestLoadAccounts(t *testing.T) {
+	path := writeConfig(t, `
+accounts:
+  - name: garden
+`)
+	c, err := Load("test", []string{"-config", path, "-account", "name=a,credential-source=file,credentials-file=/a.yaml", "-account", `name=b, "credentials-command=jq -r '{client_id: .id, client_secret: .secret}' /b.json"`}, env(map[string]string{
+		"GARDENA_EXPORTER_ACCOUNT": "name=c;name=d",
+	}))
+	if err != nil {
+		t.Fatalf("Unable to load config, got err:\n%v", err)
+	}
+	expected := []Account{
+		{Name: "a", CredentialSource: SourceFile, CredentialsFile: "/a.yaml"},
+		{Name: "b", CredentialsCommand: "jq -r '{client_id: .id, client_secret: .secret}' /b.json"},
+	}
+	if !reflect.DeepEqual(c.Accounts, expected) {
+		t.Fatalf("Expected accounts of the flags %+v, got %+v", expected, c.Accounts)
+	}
+
+	c, err = Load("test", []string{"-config", path}, env(map[string]string{"GARDENA_EXPORTER_ACCOUNT": "name=c;name=d"}))
+	if err != nil {
+		t.Fatalf("Unable to load config, got err:\n%v", err)
+	}
+	if len(c.Accounts) != 2 || c.Accounts[0].Name != "c" || c.Accounts[1].Name != "d" {
+		t.Fatalf("Expected accounts of the environment, got %+v", c.Accounts)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	path := writeConfig(t, `
+listen_address: "9093"
+api_url: localhost:8090
+metric_interval: 0
+daily_request_budget: -1
+device_types: [MOWER, ROBOT]
+accounts:
+  - name: garden
+    gateway_ip: gateway
+  - name: garden
+    credential_source: file
+  - name: yard
+    credential_source: vault
+`)
+	_, err := Load("test", []string{"-config", path}, env(nil))
+	if err == nil {
+		t.Fatalf("Expected invalid config to be rejected")
+	}
+	// all problems are reported at once
+	for _, problem := range []string{
+		"listen-address: expected host:port, got '9093'",
+		"api-url: expected http or https url, got 'localhost:8090'",
+		"metric-interval: has to be positive, got 0",
+		"daily-request-budget: can not be negative, got -1",
+		"device-types: unknown device type 'ROBOT'",
+		"account garden: gateway-ip: expected ip address or None, got 'gateway'",
+		"account: duplicate account garden",
+		"account garden: credentials-file: required for the credential source 'file'",
+		"account yard: credential-source: unknown credential source 'vault'",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Fatalf("Expected error to contain '%s', got err:\n%v", problem, err)
+		}
+	}
+
+	// values that can't be parsed are reported together with the invalid ones
+	_, err = Load("test", []string{"-metric-interval", "often", "-realtime=maybe", "-api-url", "localhost:8090"}, env(map[string]string{
+		"GARDENA_EXPORTER_STATE_INTERVAL": "daily",
+		"GARDENA_EXPORTER_GATEWAY_IP":     "gateway",
+	}))
+	for _, problem := range []string{
+		"environment variable GARDENA_EXPORTER_STATE_INTERVAL: state-interval: invalid value 'daily'",
+		"flag metric-interval: invalid value 'often'",
+		"flag realtime: invalid value 'maybe'",
+		"api-url: expected http or https url, got 'localhost:8090'",
+		"gateway-ip: expected ip address or None, got 'gateway'",
+	} {
+		if err == nil || !strings.Contains(err.Error(), problem) {
+			t.Fatalf("Expected error to contain '%s', got err:\n%v", problem, err)
+		}
+	}
+
+	_, err = Load("test", []string{"-config", writeConfig(t, "state_intervall: 10"), "-listen-address", "9093"}, env(nil))
+	for _, problem := range []string{"state_intervall", "listen-address: expected host:port, got '9093'"} {
+		if err == nil || !strings.Contains(err.Error(), problem) {
+			t.Fatalf("Expected error to contain '%s', got err:\n%v", problem, err)
+		}
+	}
+	// accounts might contain credentials, so their values aren't reported
+	_, err = Load("test", []string{"-account", "name=garden,client-secret=<garden-secret>"}, env(nil))
+	if err == nil || !strings.Contains(err.Error(), "account: invalid account garden, got err: unknown key 'client-secret'") || strings.Contains(err.Error(), "<garden-secret>") {
+		t.Fatalf("Expected account to be reported by name, got err:\n%v", err)
+	}
+	_, err = Load("test", []string{"-account", "name=garden", "-account", "<yard-secret>"}, env(nil))
+	if err == nil || !strings.Contains(err.Error(), "account: invalid account #2, got err: expected key=value, got pair 1 without '='") || strings.Contains(err.Error(), "<yard-secret>") {
+		t.Fatalf("Expected account without name to be reported by position, got err:\n%v", err)
+	}
+
+	if _, err := Load("test", []string{"-h"}, env(nil)); !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("Expected %v, got %v", flag.ErrHelp, err)
+	}
+}
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	}
+}
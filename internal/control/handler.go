@@ -0,0 +1,213 @@
+package control
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/internal/state"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena/device"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// PathPrefix is the path the Handler has to be registered at
+const PathPrefix = "/api/devices/"
+
+const commandsPath = "/commands"
+
+// Handler serves POST /api/devices/{id}/commands, translating the request into a gardena
+// command for the device with the given id. Requests have to be authenticated with the
+// configured token as bearer token.
+type Handler struct {
+	// mu guards accounts, which can be added while serving
+	mu       sync.RWMutex
+	accounts []account
+	token    string
+}
+
+// Commander sends commands to the gardena api, it's satisfied by gardena.Client
+type Commander interface {
+	SendCommandWithContext(ctx context.Context, serviceId string, cmd gardena.Command) (*gardena.CommandResult, error)
+}
+
+var _ Commander = gardena.Client(nil)
+
+// account is a Commander and the store of the devices it sends commands for
+type account struct {
+	api   Commander
+	store *state.Store
+}
+
+// CommandRequest is the body of a command request
+type CommandRequest struct {
+	Command string `json:"command"`
+	Seconds int    `json:"seconds,omitempty"`
+}
+
+// CommandResponse is the body of the response to an accepted command
+type CommandResponse struct {
+	DeviceId  string `json:"deviceId"`
+	Command   string `json:"command"`
+	Status    string `json:"status"`
+	RequestId string `json:"requestId"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewHandler creates a Handler without accounts, see AddAccount. The token must not be empty.
+func NewHandler(token string) (*Handler, error) {
+	if token == "" {
+		return nil, fmt.Errorf("token of control api can not be empty")
+	}
+	return &Handler{token: token}, nil
+}
+
+// AddAccount adds an account, commands for devices of the given store are sent with the given api.
+// Accounts can be added while the Handler is serving.
+func (h *Handler) AddAccount(api Commander, store *state.Store) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.accounts = append(h.accounts, account{api: api, store: store})
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authenticated(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gardena-smart-system-exporter"`)
+		writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "unauthorized"})
+		return
+	}
+	id, ok := deviceIdFrom(r.URL.Path)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "not found"})
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+		return
+	}
+
+	var req CommandRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid request body: %v", err)})
+		return
+	}
+	api, e, ok := h.lookup(id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: fmt.Sprintf("unknown device %s", id)})
+		return
+	}
+	cmd, err := commandFor(e.Device, req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+
+	res, err := api.SendCommandWithContext(r.Context(), id, cmd)
+	if err != nil {
+		log.Printf("Command %s for device %s failed, got err:\n%v", req.Command, id, err)
+		status := statusFor(err)
+		writeJSON(w, status, errorResponse{Error: messageFor(status)})
+		return
+	}
+	log.Printf("Sent command %s to device %s of type %s", req.Command, id, e.Device.GetDeviceType())
+	writeJSON(w, http.StatusAccepted, CommandResponse{
+		DeviceId:  id,
+		Command:   req.Command,
+		Status:    "accepted",
+		RequestId: res.RequestId,
+	})
+}
+
+// lookup returns the device with the given id and the api of its account
+func (h *Handler) lookup(id string) (Commander, state.Entry, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, a := range h.accounts {
+		if e, ok := a.store.Get(id); ok {
+			return a.api, e, true
+		}
+	}
+	return nil, state.Entry{}, false
+}
+
+// authenticated checks the bearer token of the request in constant time
+func (h *Handler) authenticated(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
+
+// commandFor creates the gardena command matching the type of the given device
+// and validates it
+func commandFor(d device.Device, req CommandRequest) (gardena.Command, error) {
+	var cmd gardena.Command
+	switch d.GetDeviceType() {
+	case device.TypeMower:
+		cmd = gardena.MowerControl{Command: gardena.MowerCommand(req.Command), Seconds: req.Seconds}
+	case device.TypeValve:
+		cmd = gardena.ValveControl{Command: gardena.ValveCommand(req.Command), Seconds: req.Seconds}
+	case device.TypePowerSocket:
+		cmd = gardena.PowerSocketControl{Command: gardena.PowerSocketCommand(req.Command), Seconds: req.Seconds}
+	default:
+		return nil, fmt.Errorf("devices of type %s don't support commands", d.GetDeviceType())
+	}
+	if err := cmd.Validate(); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// statusFor returns the status code to answer a failed command with. Commands the gardena api
+// rejected are answered with 502 Bad Gateway, commands that weren't sent due to rate limiting with
+// 503 Service Unavailable.
+func statusFor(err error) int {
+	switch {
+	case errors.Is(err, gardena.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, gardena.ErrRateLimited), errors.Is(err, gardena.ErrBudgetExhausted):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// messageFor returns the message to answer a failed command with the given status code. The error
+// itself is only logged, since it contains the endpoint and the response of the gardena api.
+func messageFor(status int) string {
+	switch status {
+	case http.StatusNotFound:
+		return "device not found by the gardena api"
+	case http.StatusServiceUnavailable:
+		return "command not sent due to rate limiting, retry later"
+	default:
+		return "command failed at the gardena api, see the logs of the exporter"
+	}
+}
+
+// deviceIdFrom extracts the device id of a path like /api/devices/{id}/commands
+func deviceIdFrom(path string) (string, bool) {
+	rest, ok := strings.CutPrefix(path, PathPrefix)
+	if !ok {
+		return "", false
+	}
+	id, ok := strings.CutSuffix(rest, commandsPath)
+	if !ok || id == "" || strings.Contains(id, "/") {
+		return "", false
+	}
+	return id, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Unable to write response, got err:\n%v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/internal/state"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena/fake"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	store := storeFromFiles(t, "../../test/location.json", "../../test/location_valves.json")
+	client := fake.NewClient()
+	h, err := NewHandler("secret")
+	if err != nil {
+		t.Fatalf("Unable to create handler, got err:\n%v", err)
+	}
+	h.AddAccount(client, store)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   string
+		status int
+	}{
+		{"mower", http.MethodPost, "/api/devices/dev-2-id/commands", "secret", `{"command":"START_SECONDS_TO_OVERRIDE","seconds":3600}`, http.StatusAccepted},
+		{"valve", http.MethodPost, "/api/devices/dev-3-id:1/commands", "secret", `{"command":"STOP_UNTIL_NEXT_TASK"}`, http.StatusAccepted},
+		{"missing token", http.MethodPost, "/api/devices/dev-2-id/commands", "", `{"command":"PARK_UNTIL_NEXT_TASK"}`, http.StatusUnauthorized},
+		{"wrong token", http.MethodPost, "/api/devices/dev-2-id/commands", "guess", `{"command":"PARK_UNTIL_NEXT_TASK"}`, http.StatusUnauthorized},
+		{"wrong method", http.MethodGet, "/api/devices/dev-2-id/commands", "secret", ``, http.StatusMethodNotAllowed},
+		{"unknown path", http.MethodPost, "/api/devices/dev-2-id", "secret", `{"command":"PARK_UNTIL_NEXT_TASK"}`, http.StatusNotFound},
+		{"unknown device", http.MethodPost, "/api/devices/dev-9-id/commands", "secret", `{"command":"PARK_UNTIL_NEXT_TASK"}`, http.StatusNotFound},
+		{"sensor", http.MethodPost, "/api/devices/dev-1-id/commands", "secret", `{"command":"PARK_UNTIL_NEXT_TASK"}`, http.StatusBadRequest},
+		{"valve command for mower", http.MethodPost, "/api/devices/dev-2-id/commands", "secret", `{"command":"STOP_UNTIL_NEXT_TASK"}`, http.StatusBadRequest},
+		{"invalid seconds", http.MethodPost, "/api/devices/dev-2-id/commands", "secret", `{"command":"START_SECONDS_TO_OVERRIDE","seconds":90}`, http.StatusBadRequest},
+		{"invalid body", http.MethodPost, "/api/devices/dev-2-id/commands", "secret", `{`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, w.Code, w.Body)
+			}
+		})
+	}
+
+	expected := []fake.SentCommand{
+		{ServiceId: "dev-2-id", Command: gardena.MowerControl{Command: gardena.MowerStartSecondsToOverride, Seconds: 3600}},
+		{ServiceId: "dev-3-id:1", Command: gardena.ValveControl{Command: gardena.ValveStopUntilNextTask}},
+	}
+	if sent := client.Commands(); !reflect.DeepEqual(sent, expected) {
+		t.Fatalf("Expected only the two valid commands %+v to be sent, got %+v", expected, sent)
+	}
+}
+
+func TestHandlerResponses(t *testing.T) {
+	store := storeFromFiles(t, "../../test/location_power_socket.json")
+	client := fake.NewClient()
+	h, _ := NewHandler("secret")
+	h.AddAccount(client, store)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, commandRequest(`{"command":"START_OVERRIDE"}`))
+	var res CommandResponse
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("Unable to decode response, got err:\n%v", err)
+	}
+	expected := CommandResponse{DeviceId: "dev-4-id", Command: "START_OVERRIDE", Status: "accepted", RequestId: "fake-request-1"}
+	if w.Code != http.StatusAccepted || res != expected {
+		t.Fatalf("Expected %d %+v, got %d %+v", http.StatusAccepted, expected, w.Code, res)
+	}
+
+	// errors of the gardena api are only logged, since they contain its endpoint and response
+	client.SetError(fmt.Errorf("PUT https://api.smart.gardena.dev/v1/command/dev-4-id answered with status code 400: INVALID_COMMAND"))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, commandRequest(`{"command":"START_OVERRIDE"}`))
+	var e errorResponse
+	if err := json.NewDecoder(w.Body).Decode(&e); err != nil {
+		t.Fatalf("Unable to decode response, got err:\n%v", err)
+	}
+	if w.Code != http.StatusBadGateway || e.Error != messageFor(http.StatusBadGateway) {
+		t.Fatalf("Expected %d without error of the api, got %d %+v", http.StatusBadGateway, w.Code, e)
+	}
+
+	// rate limited commands can be retried later
+	client.SetError(fmt.Errorf("unable to query endpoint, got err:\n %w", gardena.ErrBudgetExhausted))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, commandRequest(`{"command":"START_OVERRIDE"}`))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected %d for exhausted budget, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	if _, err := NewHandler(""); err == nil {
+		t.Fatalf("Expected handler without token to be rejected")
+	}
+}
+
+func TestHandlerAccounts(t *testing.T) {
+	garden, yard := fake.NewClient(), fake.NewClient()
+	h, _ := NewHandler("secret")
+	h.AddAccount(garden, storeFromFiles(t, "../../test/location.json"))
+	h.AddAccount(yard, storeFromFiles(t, "../../test/location_power_socket.json"))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, commandRequest(`{"command":"START_OVERRIDE"}`))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusAccepted, w.Code, w.Body)
+	}
+	if len(garden.Commands()) != 0 || len(yard.Commands()) != 1 {
+		t.Fatalf("Expected command to be sent with the api of the device's account, got %+v and %+v", garden.Commands(), yard.Commands())
+	}
+}
+
+func commandRequest(body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/devices/dev-4-id/commands", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer secret")
+	return r
+}
+
+func storeFromFiles(t *testing.T, paths ...string) *state.Store {
+	store := state.NewStore()
+	for _, path := range paths {
+		location, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Unable to read %s, got err:\n%v", path, err)
+		}
+		s := gardena.State{}
+		if err := json.Unmarshal(location, &s); err != nil {
+			t.Fatalf("Unable to create state from json, got err:\n%v", err)
+		}
+		if err := store.StoreDevices(s); err != nil {
+			t.Fatalf("Unable to store state, got err:\n%v", err)
+		}
+	}
+	return store
+}
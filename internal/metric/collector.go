@@ -0,0 +1,276 @@
+package metric
+
+import (
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/internal/state"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena/device"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// deviceLabels are the labels every device metric carries
+var deviceLabels = []string{"id", "name", "type", "location"}
+
+// timestampSuffix is appended to the name of a metric to export the time its attribute was last updated
+const timestampSuffix = "_last_updated_timestamp_seconds"
+
+// deviceMetric exports a single value of a device as gauge. If value returns
+// an error, the device doesn't support the metric and it is skipped.
+// If attr is set, the time the attribute was last updated is exported as well.
+type deviceMetric struct {
+	name  string
+	help  string
+	attr  string
+	value func(d device.Device) (float64, error)
+
+	desc   *prometheus.Desc
+	tsDesc *prometheus.Desc
+}
+
+// infoMetric exports a string attribute of a device as additional label of a gauge with the value 1
+type infoMetric struct {
+	attr   string
+	desc   *prometheus.Desc
+	tsDesc *prometheus.Desc
+}
+
+// deviceCollector is a prometheus.Collector exporting the current attributes
+// of all devices in a state.Store.
+type deviceCollector struct {
+	store   *state.Store
+	options Options
+	metrics []deviceMetric
+	infos   []infoMetric
+}
+
+// newDeviceCollector creates a deviceCollector for the given store, exporting the devices as configured
+// by the given options
+func newDeviceCollector(store *state.Store, o Options) *deviceCollector {
+	c := &deviceCollector{
+		store:   store,
+		options: o,
+		metrics: []deviceMetric{
+			newFloatAttrMetric(device.AttrBatteryLevel, "device_battery_level_percent", "The battery level of a device in percent"),
+			newFloatAttrMetric(device.AttrRFLinkLevel, "device_rf_link_level_percent", "The radio link level of a device in percent"),
+			newFloatAttrMetric(device.AttrSoilHumidity, "sensor_soil_humidity_percent", "The soil humidity measured by a sensor in percent"),
+			newFloatAttrMetric(device.AttrSoilTemp, "sensor_soil_temperature_celsius", "The soil temperature measured by a sensor in degree celsius"),
+			newFloatAttrMetric(device.AttrLightIntensity, "sensor_light_intensity_lux", "The light intensity measured by a sensor in lux"),
+			newFloatAttrMetric(device.AttrAmbientTemp, "sensor_ambient_temperature_celsius", "The ambient temperature measured by a sensor in degree celsius"),
+			newFloatAttrMetric(device.AttrOperatingHours, "mower_operating_hours", "The total operating hours of a mower"),
+			{
+				name: "mower_last_error_timestamp_seconds",
+				help: "The time the last error of a mower occurred as unix timestamp",
+				value: func(d device.Device) (float64, error) {
+					m, ok := d.(device.Mower)
+					if !ok {
+						return 0, errUnsupported
+					}
+					ts, err := m.GetLastErrorTimestamp()
+					if err != nil {
+						return 0, err
+					}
+					return float64(ts.UnixMilli()) / 1000, nil
+				},
+			},
+			newTypedFloatAttrMetric(device.TypeValve, device.AttrDuration, "valve_remaining_duration_seconds", "The remaining watering time of an open valve in seconds"),
+			{
+				name: "valve_open",
+				help: "Indicates if a valve is open and watering",
+				attr: device.AttrActivity,
+				value: func(d device.Device) (float64, error) {
+					v, ok := d.(device.Valve)
+					if !ok {
+						return 0, errUnsupported
+					}
+					if _, err := v.GetStrAttr(device.AttrActivity); err != nil {
+						return 0, err
+					}
+					return boolToFloat(v.IsOpen()), nil
+				},
+			},
+			{
+				name: "valve_set_healthy",
+				help: "Indicates if a valve set reports state OK and no error",
+				attr: device.AttrState,
+				value: func(d device.Device) (float64, error) {
+					v, ok := d.(device.ValveSet)
+					if !ok {
+						return 0, errUnsupported
+					}
+					if _, err := v.GetStrAttr(device.AttrState); err != nil {
+						return 0, err
+					}
+					return boolToFloat(v.IsHealthy()), nil
+				},
+			},
+			{
+				name: "valve_set_valves",
+				help: "The number of valves controlled by a valve set",
+				value: func(d device.Device) (float64, error) {
+					v, ok := d.(device.ValveSet)
+					if !ok {
+						return 0, errUnsupported
+					}
+					return float64(len(v.GetValveIds())), nil
+				},
+			},
+			newTypedFloatAttrMetric(device.TypePowerSocket, device.AttrDuration, "power_socket_remaining_duration_seconds", "The remaining time a power socket is switched on in seconds"),
+			{
+				name: "power_socket_on",
+				help: "Indicates if a power socket is switched on",
+				attr: device.AttrActivity,
+				value: func(d device.Device) (float64, error) {
+					p, ok := d.(device.PowerSocket)
+					if !ok {
+						return 0, errUnsupported
+					}
+					if _, err := p.GetStrAttr(device.AttrActivity); err != nil {
+						return 0, err
+					}
+					return boolToFloat(p.IsOn()), nil
+				},
+			},
+			{
+				name: "device_schema_warnings",
+				help: "The number of attributes of a device that were reported with an unexpected kind or a malformed timestamp",
+				value: func(d device.Device) (float64, error) {
+					return float64(len(d.GetWarnings())), nil
+				},
+			},
+		},
+		infos: []infoMetric{
+			newInfoMetric(device.AttrState, "device_state", "state", "The state of a device as label, e.g. OK, WARNING or ERROR"),
+			newInfoMetric(device.AttrActivity, "device_activity", "activity", "The current activity of a device as label"),
+			newInfoMetric(device.AttrLastErrorCode, "device_last_error_code", "error_code", "The last error code of a device as label, NO_MESSAGE if there is no error"),
+		},
+	}
+	for i, m := range c.metrics {
+		c.metrics[i].desc = newDeviceDesc(m.name, m.help, nil)
+		if m.attr != "" && !o.DisableTimestamps {
+			c.metrics[i].tsDesc = newTimestampDesc(m.name)
+		}
+	}
+	if o.DisableTimestamps {
+		for i := range c.infos {
+			c.infos[i].tsDesc = nil
+		}
+	}
+	return c
+}
+
+func newDeviceDesc(name, help string, extraLabels []string) *prometheus.Desc {
+	labels := append(append([]string{}, deviceLabels...), extraLabels...)
+	return prometheus.NewDesc(prometheus.BuildFQName(metricNameSpace, "", name), help, labels, nil)
+}
+
+// newTimestampDesc creates the description of a metric exporting the time the attribute
+// of the metric with the given name was last updated
+func newTimestampDesc(name string) *prometheus.Desc {
+	return newDeviceDesc(name+timestampSuffix, "The time the attribute of "+name+" was last updated as unix timestamp", nil)
+}
+
+// newFloatAttrMetric creates a deviceMetric exporting a float attribute of any device supporting it
+func newFloatAttrMetric(attr, name, help string) deviceMetric {
+	return deviceMetric{
+		name: name,
+		help: help,
+		attr: attr,
+		value: func(d device.Device) (float64, error) {
+			return d.GetFloatAttr(attr)
+		},
+	}
+}
+
+// newTypedFloatAttrMetric creates a deviceMetric exporting a float attribute only for devices of the given type
+func newTypedFloatAttrMetric(deviceType, attr, name, help string) deviceMetric {
+	m := newFloatAttrMetric(attr, name, help)
+	value := m.value
+	m.value = func(d device.Device) (float64, error) {
+		if d.GetDeviceType() != deviceType {
+			return 0, errUnsupported
+		}
+		return value(d)
+	}
+	return m
+}
+
+// newInfoMetric creates an info metric exporting the value of the given attribute as the given label
+func newInfoMetric(attr, name, label, help string) infoMetric {
+	return infoMetric{
+		attr:   attr,
+		desc:   newDeviceDesc(name, help, []string{label}),
+		tsDesc: newTimestampDesc(name),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *deviceCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics {
+		ch <- m.desc
+		if m.tsDesc != nil {
+			ch <- m.tsDesc
+		}
+	}
+	for _, m := range c.infos {
+		ch <- m.desc
+		if m.tsDesc != nil {
+			ch <- m.tsDesc
+		}
+	}
+}
+
+// Collect implements prometheus.Collector. Each device of the store exports every
+// metric it has an attribute for, attributes a device doesn't support or didn't report
+// are skipped. Attributes reported without timestamp export no timestamp metric. Devices of types
+// that aren't enabled by the options are skipped.
+func (c *deviceCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, e := range c.store.Entries() {
+		if !c.options.exports(e.Device) {
+			continue
+		}
+		labels := labelValuesFor(e)
+		for _, m := range c.metrics {
+			v, err := m.value(e.Device)
+			if err != nil {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(m.desc, prometheus.GaugeValue, v, labels...)
+			if m.tsDesc != nil {
+				collectTimestamp(ch, m.tsDesc, e.Device, m.attr, labels)
+			}
+		}
+		for _, m := range c.infos {
+			v, err := e.Device.GetStrAttr(m.attr)
+			if err != nil || v == "" {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(m.desc, prometheus.GaugeValue, 1, append(labels, v)...)
+			if m.tsDesc != nil {
+				collectTimestamp(ch, m.tsDesc, e.Device, m.attr, labels)
+			}
+		}
+	}
+}
+
+// collectTimestamp exports the time the given attribute of a device was last updated, if known
+func collectTimestamp(ch chan<- prometheus.Metric, desc *prometheus.Desc, d device.Device, attr string, labels []string) {
+	ts, err := d.GetAttrTimestamp(attr)
+	if err != nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(ts.UnixMilli())/1000, labels...)
+}
+
+// labelValuesFor returns the values for deviceLabels of a given store entry
+func labelValuesFor(e state.Entry) []string {
+	name, err := e.Device.GetStrAttr(device.AttrName)
+	if err != nil {
+		name = ""
+	}
+	return []string{e.Device.GetDeviceId(), name, e.Device.GetDeviceType(), e.Location}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
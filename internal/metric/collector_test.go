@@ -0,0 +1,163 @@
+package metric
+
+import (
+	"encoding/json"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/internal/state"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena/device"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDeviceCollector(t *testing.T) {
+	c := newDeviceCollector(storeFromFile(t, "../../test/location.json"), Options{})
+
+	expected := `
+# HELP gardena_smart_system_device_battery_level_percent The battery level of a device in percent
+# TYPE gardena_smart_system_device_battery_level_percent gauge
+gardena_smart_system_device_battery_level_percent{id="dev-1-id",location="GARDENA smart Garden",name="Sensor01",type="SENSOR"} 100
+gardena_smart_system_device_battery_level_percent{id="dev-2-id",location="GARDENA smart Garden",name="SILENO",type="MOWER"} 100
+# HELP gardena_smart_system_device_last_error_code The last error code of a device as label, NO_MESSAGE if there is no error
+# TYPE gardena_smart_system_device_last_error_code gauge
+gardena_smart_system_device_last_error_code{error_code="NO_MESSAGE",id="dev-2-id",location="GARDENA smart Garden",name="SILENO",type="MOWER"} 1
+# HELP gardena_smart_system_mower_operating_hours The total operating hours of a mower
+# TYPE gardena_smart_system_mower_operating_hours gauge
+gardena_smart_system_mower_operating_hours{id="dev-2-id",location="GARDENA smart Garden",name="SILENO",type="MOWER"} 435
+# HELP gardena_smart_system_sensor_ambient_temperature_celsius The ambient temperature measured by a sensor in degree celsius
+# TYPE gardena_smart_system_sensor_ambient_temperature_celsius gauge
+gardena_smart_system_sensor_ambient_temperature_celsius{id="dev-1-id",location="GARDENA smart Garden",name="Sensor01",type="SENSOR"} 27
+# HELP gardena_smart_system_sensor_light_intensity_lux The light intensity measured by a sensor in lux
+# TYPE gardena_smart_system_sensor_light_intensity_lux gauge
+gardena_smart_system_sensor_light_intensity_lux{id="dev-1-id",location="GARDENA smart Garden",name="Sensor01",type="SENSOR"} 3500
+# HELP gardena_smart_system_sensor_soil_humidity_percent The soil humidity measured by a sensor in percent
+# TYPE gardena_smart_system_sensor_soil_humidity_percent gauge
+gardena_smart_system_sensor_soil_humidity_percent{id="dev-1-id",location="GARDENA smart Garden",name="Sensor01",type="SENSOR"} 95
+# HELP gardena_smart_system_sensor_soil_humidity_percent_last_updated_timestamp_seconds The time the attribute of sensor_soil_humidity_percent was last updated as unix timestamp
+# TYPE gardena_smart_system_sensor_soil_humidity_percent_last_updated_timestamp_seconds gauge
+gardena_smart_system_sensor_soil_humidity_percent_last_updated_timestamp_seconds{id="dev-1-id",location="GARDENA smart Garden",name="Sensor01",type="SENSOR"} 1.686245982e+09
+`
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"gardena_smart_system_device_battery_level_percent",
+		"gardena_smart_system_device_last_error_code",
+		"gardena_smart_system_mower_operating_hours",
+		"gardena_smart_system_sensor_ambient_temperature_celsius",
+		"gardena_smart_system_sensor_light_intensity_lux",
+		"gardena_smart_system_sensor_soil_humidity_percent",
+		"gardena_smart_system_sensor_soil_humidity_percent_last_updated_timestamp_seconds",
+		// reported without timestamp
+		"gardena_smart_system_mower_operating_hours_last_updated_timestamp_seconds")
+	if err != nil {
+		t.Fatalf("Unexpected metrics:\n%v", err)
+	}
+
+	// 2 devices with battery, rf link and schema warnings, 1 sensor with 4 measurements, 1 mower with
+	// state, activity, last error code and operating hours, all but the schema warnings and operating
+	// hours with timestamp
+	if n := testutil.CollectAndCount(c); n != 25 {
+		t.Fatalf("Expected 25 metrics, got %d", n)
+	}
+}
+
+func TestDeviceCollectorValves(t *testing.T) {
+	c := newDeviceCollector(storeFromFile(t, "../../test/location_valves.json"), Options{})
+
+	expected := `
+# HELP gardena_smart_system_device_activity The current activity of a device as label
+# TYPE gardena_smart_system_device_activity gauge
+gardena_smart_system_device_activity{activity="MANUAL_WATERING",id="dev-3-id:1",location="Backyard",name="Lawn",type="VALVE"} 1
+gardena_smart_system_device_activity{activity="CLOSED",id="dev-3-id:2",location="Backyard",name="Beds",type="VALVE"} 1
+# HELP gardena_smart_system_device_state The state of a device as label, e.g. OK, WARNING or ERROR
+# TYPE gardena_smart_system_device_state gauge
+gardena_smart_system_device_state{id="dev-3-id",location="Backyard",name="Irrigation Control",state="WARNING",type="VALVE_SET"} 1
+gardena_smart_system_device_state{id="dev-3-id:1",location="Backyard",name="Lawn",state="OK",type="VALVE"} 1
+gardena_smart_system_device_state{id="dev-3-id:2",location="Backyard",name="Beds",state="OK",type="VALVE"} 1
+# HELP gardena_smart_system_device_rf_link_level_percent The radio link level of a device in percent
+# TYPE gardena_smart_system_device_rf_link_level_percent gauge
+gardena_smart_system_device_rf_link_level_percent{id="dev-3-id",location="Backyard",name="Irrigation Control",type="VALVE_SET"} 90
+gardena_smart_system_device_rf_link_level_percent{id="dev-3-id:1",location="Backyard",name="Lawn",type="VALVE"} 90
+gardena_smart_system_device_rf_link_level_percent{id="dev-3-id:2",location="Backyard",name="Beds",type="VALVE"} 90
+# HELP gardena_smart_system_valve_open Indicates if a valve is open and watering
+# TYPE gardena_smart_system_valve_open gauge
+gardena_smart_system_valve_open{id="dev-3-id:1",location="Backyard",name="Lawn",type="VALVE"} 1
+gardena_smart_system_valve_open{id="dev-3-id:2",location="Backyard",name="Beds",type="VALVE"} 0
+# HELP gardena_smart_system_valve_remaining_duration_seconds The remaining watering time of an open valve in seconds
+# TYPE gardena_smart_system_valve_remaining_duration_seconds gauge
+gardena_smart_system_valve_remaining_duration_seconds{id="dev-3-id:1",location="Backyard",name="Lawn",type="VALVE"} 1800
+# HELP gardena_smart_system_valve_set_healthy Indicates if a valve set reports state OK and no error
+# TYPE gardena_smart_system_valve_set_healthy gauge
+gardena_smart_system_valve_set_healthy{id="dev-3-id",location="Backyard",name="Irrigation Control",type="VALVE_SET"} 0
+# HELP gardena_smart_system_valve_set_valves The number of valves controlled by a valve set
+# TYPE gardena_smart_system_valve_set_valves gauge
+gardena_smart_system_valve_set_valves{id="dev-3-id",location="Backyard",name="Irrigation Control",type="VALVE_SET"} 2
+`
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"gardena_smart_system_device_activity",
+		"gardena_smart_system_device_state",
+		"gardena_smart_system_device_rf_link_level_percent",
+		"gardena_smart_system_valve_open",
+		"gardena_smart_system_valve_remaining_duration_seconds",
+		"gardena_smart_system_valve_set_healthy",
+		"gardena_smart_system_valve_set_valves")
+	if err != nil {
+		t.Fatalf("Unexpected metrics:\n%v", err)
+	}
+}
+
+// storeFromFile creates a state.Store from a location state json file
+func storeFromFile(t *testing.T, path string) *state.Store {
+	location, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unable to read %s, got err:\n%v", path, err)
+	}
+	s := gardena.State{}
+	if err := json.Unmarshal(location, &s); err != nil {
+		t.Fatalf("Unable to create state from json, got err:\n%v", err)
+	}
+	store := state.NewStore()
+	if err := store.StoreDevices(s); err != nil {
+		t.Fatalf("Unable to store state, got err:\n%v", err)
+	}
+	return store
+}
+
+func TestDeviceCollectorPowerSocket(t *testing.T) {
+	c := newDeviceCollector(storeFromFile(t, "../../test/location_power_socket.json"), Options{})
+
+	expected := `
+# HELP gardena_smart_system_power_socket_on Indicates if a power socket is switched on
+# TYPE gardena_smart_system_power_socket_on gauge
+gardena_smart_system_power_socket_on{id="dev-4-id",location="Pond",name="Pond Pump",type="POWER_SOCKET"} 1
+# HELP gardena_smart_system_power_socket_remaining_duration_seconds The remaining time a power socket is switched on in seconds
+# TYPE gardena_smart_system_power_socket_remaining_duration_seconds gauge
+gardena_smart_system_power_socket_remaining_duration_seconds{id="dev-4-id",location="Pond",name="Pond Pump",type="POWER_SOCKET"} 3600
+`
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"gardena_smart_system_power_socket_on",
+		"gardena_smart_system_power_socket_remaining_duration_seconds")
+	if err != nil {
+		t.Fatalf("Unexpected metrics:\n%v", err)
+	}
+}
+
+func TestDeviceCollectorOptions(t *testing.T) {
+	c := newDeviceCollector(storeFromFile(t, "../../test/location.json"), Options{
+		DeviceTypes:       []string{device.TypeMower},
+		DisableTimestamps: true,
+	})
+
+	expected := `
+# HELP gardena_smart_system_device_battery_level_percent The battery level of a device in percent
+# TYPE gardena_smart_system_device_battery_level_percent gauge
+gardena_smart_system_device_battery_level_percent{id="dev-2-id",location="GARDENA smart Garden",name="SILENO",type="MOWER"} 100
+`
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"gardena_smart_system_device_battery_level_percent",
+		// sensors aren't exported
+		"gardena_smart_system_sensor_soil_humidity_percent",
+		"gardena_smart_system_device_activity_last_updated_timestamp_seconds")
+	if err != nil {
+		t.Fatalf("Unexpected metrics:\n%v", err)
+	}
+}
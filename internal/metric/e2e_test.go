@@ -0,0 +1,165 @@
+package metric
+
+import (
+	"context"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena/device"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena/gardenatest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestEndToEnd runs the generator against a fake gardena cloud, from authentication over the
+// periodic refresh to realtime updates of the devices.
+func TestEndToEnd(t *testing.T) {
+	cloud := gardenatest.NewServer()
+	defer cloud.Close()
+	for _, f := range []string{"../../test/location.json", "../../test/location_valves.json"} {
+		if err := cloud.LoadFixture(f); err != nil {
+			t.Fatalf("Unable to load fixture, got err:\n%v", err)
+		}
+	}
+	secrets := t.TempDir()
+	os.WriteFile(filepath.Join(secrets, "client-id"), []byte("id\n"), 0600)
+	os.WriteFile(filepath.Join(secrets, "client-secret"), []byte("secret\n"), 0600)
+
+	api, err := gardena.NewAPI().
+		WithBaseURL(cloud.APIURL()).
+		WithAuthURL(cloud.AuthURL()).
+		WithSecretFilePath(secrets).
+		Initialize()
+	if err != nil {
+		t.Fatalf("Unable to initialize api, got err:\n%v", err)
+	}
+	g := NewGenerator(api, EmptyGatewayIP)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := g.InitializeLocationsMetrics(ctx); err != nil {
+		t.Fatalf("Unable to initialize metrics, got err:\n%v", err)
+	}
+	if n := len(g.Store().Entries()); n != 5 {
+		t.Fatalf("Expected 5 devices, got %d", n)
+	}
+
+	// changes of the periodic refresh
+	cloud.SetAttribute("dev-1-id", device.TypeSensor, device.AttrSoilHumidity, 42)
+	if err := g.RefreshState(ctx); err != nil {
+		t.Fatalf("Unable to refresh state, got err:\n%v", err)
+	}
+	if v := floatAttrOf(t, g, "dev-1-id", device.AttrSoilHumidity); v != 42 {
+		t.Fatalf("Expected refreshed soil humidity 42, got %v", v)
+	}
+
+	// changes pushed by the realtime api
+	realtimeCtx, stop := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() { done <- g.RunRealtime(realtimeCtx) }()
+	for v := 0.0; v != 7; v = floatAttrOf(t, g, "dev-1-id", device.AttrSoilHumidity) {
+		// websockets might not be connected yet, so the update is repeated until it's received
+		cloud.SetAttribute("dev-1-id", device.TypeSensor, device.AttrSoilHumidity, 7)
+		select {
+		case <-ctx.Done():
+			t.Fatalf("Realtime update wasn't received, soil humidity is %v", v)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	stop()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Expected realtime to stop with %v, got %v", context.Canceled, err)
+	}
+}
+
+// TestRealtimeLocations checks that realtime updates are received despite failing to query the
+// locations at first, and that locations added or removed later are subscribed or unsubscribed.
+func TestRealtimeLocations(t *testing.T) {
+	cloud := gardenatest.NewServer()
+	defer cloud.Close()
+	if err := cloud.LoadFixture("../../test/location.json"); err != nil {
+		t.Fatalf("Unable to load fixture, got err:\n%v", err)
+	}
+	secrets := t.TempDir()
+	os.WriteFile(filepath.Join(secrets, "client-id"), []byte("id\n"), 0600)
+	os.WriteFile(filepath.Join(secrets, "client-secret"), []byte("secret\n"), 0600)
+
+	api, err := gardena.NewAPI().
+		WithBaseURL(cloud.APIURL()).
+		WithAuthURL(cloud.AuthURL()).
+		WithSecretFilePath(secrets).
+		Initialize()
+	if err != nil {
+		t.Fatalf("Unable to initialize api, got err:\n%v", err)
+	}
+	g := NewAccountGenerator("realtime", api, EmptyGatewayIP)
+	g.realtimeBackoff = gardena.Backoff{Min: time.Millisecond, Max: 10 * time.Millisecond}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := g.InitializeLocationsMetrics(ctx); err != nil {
+		t.Fatalf("Unable to initialize metrics, got err:\n%v", err)
+	}
+
+	cloud.FailNext(gardena.LocationsURL, 503)
+	cloud.FailNext(gardena.LocationsURL, 503)
+	realtimeCtx, stop := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() { done <- g.RunRealtime(realtimeCtx) }()
+	for v := 0.0; v != 7; v = floatAttrOf(t, g, "dev-1-id", device.AttrSoilHumidity) {
+		cloud.SetAttribute("dev-1-id", device.TypeSensor, device.AttrSoilHumidity, 7)
+		select {
+		case <-ctx.Done():
+			t.Fatalf("Realtime update wasn't received after failed location queries, soil humidity is %v", v)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	// a location added later is subscribed by the next refresh
+	if err := cloud.LoadFixture("../../test/location_valves.json"); err != nil {
+		t.Fatalf("Unable to load fixture, got err:\n%v", err)
+	}
+	if err := g.RefreshState(ctx); err != nil {
+		t.Fatalf("Unable to refresh state, got err:\n%v", err)
+	}
+	for v := 0.0; v != 5; v = floatAttrOf(t, g, "dev-3-id:1", device.AttrRFLinkLevel) {
+		cloud.SetAttribute("dev-3-id", device.CommonType, device.AttrRFLinkLevel, 5)
+		select {
+		case <-ctx.Done():
+			t.Fatalf("Realtime update of added location wasn't received, rf link level is %v", v)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	// a removed location is unsubscribed by the next refresh
+	cloud.RemoveLocation("location-2-id")
+	if err := g.RefreshState(ctx); err != nil {
+		t.Fatalf("Unable to refresh state, got err:\n%v", err)
+	}
+	g.realtimeMu.Lock()
+	_, subscribed := g.realtime["location-2-id"]
+	n := len(g.realtime)
+	g.realtimeMu.Unlock()
+	if subscribed || n != 1 {
+		t.Fatalf("Expected only location-1-id to be subscribed, got %d subscriptions", n)
+	}
+
+	stop()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Expected realtime to stop with %v, got %v", context.Canceled, err)
+	}
+	if n := testutil.CollectAndCount(realtimeConnected); n != 0 {
+		t.Fatalf("Expected connection metrics of stopped subscriptions to be deleted, got %d", n)
+	}
+}
+
+func floatAttrOf(t *testing.T, g *Generator, id, attr string) float64 {
+	e, ok := g.Store().Get(id)
+	if !ok {
+		t.Fatalf("Device %s not found", id)
+	}
+	v, err := e.Device.GetFloatAttr(attr)
+	if err != nil {
+		t.Fatalf("Unable to get %s of %s, got err:\n%v", attr, id, err)
+	}
+	return v
+}
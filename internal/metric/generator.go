@@ -1,88 +1,428 @@
 package metric
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/Christoph-Raab/gardena-smart-system-exporter/internal/state"
 	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena/device"
 	"github.com/prometheus/client_golang/prometheus"
 	"log"
 	"net/http"
+	"sync"
+	"time"
 )
 
 const EmptyGatewayIP = "None"
 
+// DefaultAccount is the account of a Generator created by NewGenerator
+const DefaultAccount = "default"
+
 type Generator struct {
-	api       gardena.API
-	store     state.Store
+	account   string
+	api       gardena.Client
+	store     *state.Store
 	gatewayIP string
+	options   Options
+
+	// realtimeMu guards the realtime subscriptions by location id, realtimeCtx is only set while
+	// RunRealtime is running
+	realtimeMu      sync.Mutex
+	realtimeCtx     context.Context
+	realtime        map[string]context.CancelFunc
+	realtimeWg      sync.WaitGroup
+	realtimeBackoff gardena.Backoff
+}
+
+// Options configure which metrics a Generator exports
+type Options struct {
+	// DeviceTypes are the types of the devices exported, e.g. device.TypeMower. All devices are
+	// exported if empty.
+	DeviceTypes []string
+	// DisableTimestamps disables the metrics of the time the attributes of devices were last updated
+	DisableTimestamps bool
+}
+
+// exports reports if the given device is exported
+func (o Options) exports(d device.Device) bool {
+	if len(o.DeviceTypes) == 0 {
+		return true
+	}
+	for _, t := range o.DeviceTypes {
+		if d.GetDeviceType() == t {
+			return true
+		}
+	}
+	return false
 }
 
-// NewGenerator creates a new Generator with a given gardena.API and a gatewayIP as string
-func NewGenerator(api gardena.API, gatewayIP string) *Generator {
+// NewGenerator creates a new Generator with a given gardena.Client and a gatewayIP as string
+// for the DefaultAccount
+func NewGenerator(api gardena.Client, gatewayIP string) *Generator {
+	return NewAccountGenerator(DefaultAccount, api, gatewayIP)
+}
+
+// NewAccountGenerator creates a new Generator for the account with the given name. All metrics
+// of the Generator carry the name as account label, so the metrics of multiple accounts can be
+// told apart.
+func NewAccountGenerator(account string, api gardena.Client, gatewayIP string) *Generator {
 	var g Generator
+	g.account = account
 	g.api = api
 	g.gatewayIP = gatewayIP
 	g.store = state.NewStore()
+	count := countMowerErrors(account)
+	g.store.OnChange(func(old device.Device, e state.Entry) {
+		if g.options.exports(e.Device) {
+			count(old, e)
+		}
+	})
+	g.store.OnRemove(deleteMowerErrors(account))
 	return &g
 }
 
+// WithOptions configures the metrics of the Generator, it has to be called before Register
+func (g *Generator) WithOptions(o Options) *Generator {
+	g.options = o
+	return g
+}
+
+// countMowerErrors returns a callback counting each new error of a mower of the given account, that
+// is an error code other than NO_MESSAGE, which differs from the previous error code or occurred at
+// a different time. Errors present when a mower is first seen are not counted, since they might have
+// been counted before a restart.
+func countMowerErrors(account string) func(old device.Device, e state.Entry) {
+	return func(old device.Device, e state.Entry) {
+		countMowerError(account, old, e)
+	}
+}
+
+func countMowerError(account string, old device.Device, e state.Entry) {
+	m, ok := e.Device.(device.Mower)
+	if !ok || old == nil || !m.HasError() {
+		return
+	}
+	code, _ := m.GetStrAttr(device.AttrLastErrorCode)
+	ts, _ := m.GetLastErrorTimestamp()
+	oldCode, _ := old.GetStrAttr(device.AttrLastErrorCode)
+	var oldTs time.Time
+	if o, ok := old.(device.Mower); ok {
+		oldTs, _ = o.GetLastErrorTimestamp()
+	}
+	if code == oldCode && ts.Equal(oldTs) {
+		return
+	}
+	mowerErrors.WithLabelValues(append(append([]string{account}, labelValuesFor(e)...), code)...).Inc()
+}
+
+// deleteMowerErrors returns a callback deleting the error counts of a removed mower of the given account
+func deleteMowerErrors(account string) func(id string) {
+	return func(id string) {
+		mowerErrors.DeletePartialMatch(prometheus.Labels{accountLabel: account, "id": id})
+	}
+}
+
+// Account returns the name of the account of the generator
+func (g *Generator) Account() string {
+	return g.account
+}
+
+// Store returns the store holding the devices of the generator
+func (g *Generator) Store() *state.Store {
+	return g.store
+}
+
+// rateLimitedClient is a gardena.Client limiting its requests, like gardena.API
+type rateLimitedClient interface {
+	RateLimitStatus() gardena.RateLimitStatus
+}
+
+// tokenClient is a gardena.Client authenticating with an expiring access token, like gardena.API
+type tokenClient interface {
+	TokenExpiry() time.Time
+}
+
+// reloadingClient is a gardena.Client reloading rotated credentials, like gardena.API
+type reloadingClient interface {
+	CredentialReloadStatus() gardena.CredentialReloadStatus
+}
+
+// Register registers the collectors exporting the devices of the generator's store with the given
+// prometheus.Registerer. If the client of the generator supports it, its rate limiting, token
+// expiry and credential reloads are exported as well. All collectors carry the account label.
+// If a collector fails to register, the ones registered before are unregistered again, so
+// Register can be retried.
+func (g *Generator) Register(r prometheus.Registerer) (err error) {
+	r = prometheus.WrapRegistererWith(prometheus.Labels{accountLabel: g.account}, r)
+	var registered []prometheus.Collector
+	defer func() {
+		if err != nil {
+			for _, c := range registered {
+				r.Unregister(c)
+			}
+		}
+	}()
+	register := func(c prometheus.Collector) error {
+		if err := r.Register(c); err != nil {
+			return err
+		}
+		registered = append(registered, c)
+		return nil
+	}
+
+	if err := register(newDeviceCollector(g.store, g.options)); err != nil {
+		return fmt.Errorf("unable to register device collector, got err:\n%w", err)
+	}
+	if c, ok := g.api.(rateLimitedClient); ok {
+		if err := register(newRateLimitCollector(c.RateLimitStatus)); err != nil {
+			return fmt.Errorf("unable to register rate limit collector, got err:\n%w", err)
+		}
+	}
+	if c, ok := g.api.(tokenClient); ok {
+		tokenExpiry := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricNameSpace,
+			Name:      "api_token_expiry_timestamp_seconds",
+			Help:      "The time the access token of the api expires as unix timestamp",
+		}, func() float64 {
+			expAt := c.TokenExpiry()
+			if expAt.IsZero() {
+				return 0
+			}
+			return float64(expAt.UnixMilli()) / 1000
+		})
+		if err := register(tokenExpiry); err != nil {
+			return fmt.Errorf("unable to register token expiry metric, got err:\n%w", err)
+		}
+	}
+	if c, ok := g.api.(reloadingClient); ok {
+		if err := register(newCredentialReloadCollector(c.CredentialReloadStatus)); err != nil {
+			return fmt.Errorf("unable to register credential reload collector, got err:\n%w", err)
+		}
+	}
+	return nil
+}
+
+// AccountStarted records the result of an attempt to start the account with the given name,
+// exported as account_up and account_start_errors_total
+func AccountStarted(account string, err error) {
+	if err != nil {
+		accountUp.WithLabelValues(account).Set(0)
+		accountStartErrors.WithLabelValues(account).Inc()
+		return
+	}
+	accountUp.WithLabelValues(account).Set(1)
+}
+
 // InitializeLocationsMetrics queries all locations and for each location it adds the location's
 // devices to the generator's store. It also sets up a metric about the number of locations.
-func (g *Generator) InitializeLocationsMetrics() error {
-	locations, err := g.api.GetLocations()
+func (g *Generator) InitializeLocationsMetrics(ctx context.Context) error {
+	return g.RefreshState(ctx)
+}
+
+// RefreshState queries all locations and reconciles the generator's store with the current state
+// of each location. Devices of locations that no longer exist are removed from the store.
+// The duration of the refresh and failed refreshes by reason are exported as metric. Canceling the given
+// context aborts the refresh, leaving locations that were not refreshed yet untouched.
+func (g *Generator) RefreshState(ctx context.Context) error {
+	timer := prometheus.NewTimer(stateRefreshDuration.WithLabelValues(g.account))
+	defer timer.ObserveDuration()
+
+	if err := g.refreshState(ctx); err != nil {
+		reason := errorReason(err)
+		stateRefreshErrors.WithLabelValues(g.account, reason).Inc()
+		if reason == "unauthorized" || reason == "forbidden" {
+			log.Printf("The gardena api rejected the credentials of account %s, check the client-id and client-secret", g.account)
+		}
+		return err
+	}
+	return nil
+}
+
+// errorReason classifies an error of the gardena api, so failures due to the credentials can be
+// told apart from transient failures like rate limiting or outages of the api
+func errorReason(err error) string {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	case errors.Is(err, gardena.ErrUnauthorized):
+		return "unauthorized"
+	case errors.Is(err, gardena.ErrForbidden):
+		return "forbidden"
+	case errors.Is(err, gardena.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, gardena.ErrRateLimited):
+		return "rate_limited"
+	case errors.Is(err, gardena.ErrBudgetExhausted):
+		return "budget_exhausted"
+	case errors.Is(err, gardena.ErrServerError):
+		return "server_error"
+	case errors.Is(err, gardena.ErrDecode):
+		return "decode"
+	default:
+		return "other"
+	}
+}
+
+func (g *Generator) refreshState(ctx context.Context) error {
+	locations, err := g.api.GetLocationsWithContext(ctx)
 	if err != nil {
-		return fmt.Errorf("unable to get locations, got errer:\n%w", err)
+		return fmt.Errorf("unable to get locations, got err:\n%w", err)
 	}
-	locationsTotal.WithLabelValues(g.api.GetBaseURL()).Set(float64(len(locations.Data)))
+	locationsTotal.WithLabelValues(g.account, g.api.GetBaseURL()).Set(float64(len(locations.Data)))
 
+	var ids []string
 	for _, l := range locations.Data {
 		// Returns: Ref test/location.json
-		s, err := g.api.GetInitialStateFor(l.Location)
+		s, err := g.api.GetInitialStateForWithContext(ctx, l.Location)
 		if err != nil {
-			return fmt.Errorf("getting initial state for location %s failed, got err:\n%w", s.Data.Id, err)
+			return fmt.Errorf("getting state for location %s failed, got err:\n%w", l.Id, err)
 		}
 
 		// list 6 objs (2 DEVICE, 2 COMMON, MOWER, SENSOR) -> store as 2 devices
-		err = g.store.StoreDevices(*s)
+		c, err := g.store.Reconcile(*s)
 		if err != nil {
 			return fmt.Errorf("storing devices for location %s failed with err:\n%w", l.Id, err)
 		}
+		if !c.Empty() {
+			log.Printf("Refreshed location %s: added %v, updated %v, removed %v", l.Id, c.Added, c.Updated, c.Removed)
+		}
+		ids = append(ids, l.Id)
 	}
+	if removed := g.store.RetainLocations(ids); len(removed) > 0 {
+		log.Printf("Removed devices %v of locations that no longer exist", removed)
+	}
+	g.syncRealtime(locations)
 	return nil
 }
 
+// RunRealtime subscribes to the realtime updates of all locations and applies every update to the
+// generator's store. Lost connections are reestablished. If the locations can't be queried, the query
+// is retried with the backoff of the websocket reconnects. Afterwards, every RefreshState subscribes
+// to new locations and unsubscribes from removed ones. RunRealtime blocks until the given context
+// is canceled.
+func (g *Generator) RunRealtime(ctx context.Context) error {
+	g.realtimeMu.Lock()
+	g.realtimeCtx = ctx
+	g.realtime = make(map[string]context.CancelFunc)
+	g.realtimeMu.Unlock()
+	defer func() {
+		g.realtimeMu.Lock()
+		g.realtimeCtx = nil
+		g.realtimeMu.Unlock()
+		g.realtimeWg.Wait()
+	}()
+
+	backoff := g.realtimeBackoff
+	for {
+		locations, err := g.api.GetLocationsWithContext(ctx)
+		if err == nil {
+			g.syncRealtime(locations)
+			break
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		wait := backoff.Next()
+		log.Printf("Unable to get locations of account %s for realtime updates, retrying in %v, got err:\n%v", g.account, wait, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// syncRealtime subscribes to the realtime updates of the given locations which aren't subscribed
+// yet and cancels the subscriptions of all other locations. It does nothing unless RunRealtime is
+// running.
+func (g *Generator) syncRealtime(locations *gardena.Locations) {
+	g.realtimeMu.Lock()
+	defer g.realtimeMu.Unlock()
+	if g.realtimeCtx == nil {
+		return
+	}
+	keep := make(map[string]bool, len(locations.Data))
+	for _, l := range locations.Data {
+		keep[l.Id] = true
+		if _, ok := g.realtime[l.Id]; !ok {
+			g.realtime[l.Id] = g.subscribe(l.Location)
+		}
+	}
+	for id, cancel := range g.realtime {
+		if !keep[id] {
+			log.Printf("Unsubscribing from realtime updates of removed location %s", id)
+			cancel()
+			delete(g.realtime, id)
+		}
+	}
+}
+
+// subscribe receives the realtime updates of the given location in the background, until the
+// returned function or the context of RunRealtime is canceled. The caller must hold realtimeMu.
+func (g *Generator) subscribe(l gardena.Location) context.CancelFunc {
+	ctx, cancel := context.WithCancel(g.realtimeCtx)
+	rt := gardena.NewRealtime(g.api, l).OnConnectionChange(func(connected bool) {
+		v := 0
+		if connected {
+			v = 1
+		}
+		realtimeConnected.WithLabelValues(g.account, l.Id).Set(float64(v))
+	})
+	g.realtimeWg.Add(1)
+	go func() {
+		defer g.realtimeWg.Done()
+		rt.Run(ctx, func(update gardena.Device) {
+			realtimeEvents.WithLabelValues(g.account, update.Type).Inc()
+			if _, err := g.store.Apply(l, update); err != nil {
+				log.Printf("Unable to apply realtime update of location %s, got err:\n%v", l.Id, err)
+			}
+		})
+		realtimeConnected.DeleteLabelValues(g.account, l.Id)
+	}()
+	return cancel
+}
+
 // MonitorHealthOfEndpoints checks if the configured api health endpoint and the gateway bridge device
 // are healthy by querying the endpoint urls. The result is exported as metric.
 // If no ip for the bridge device is configured, this endpoint is ignored.
-func (g *Generator) MonitorHealthOfEndpoints() {
-	timer := prometheus.NewTimer(endpointHealthCheckDuration.WithLabelValues())
+func (g *Generator) MonitorHealthOfEndpoints(ctx context.Context) {
+	timer := prometheus.NewTimer(endpointHealthCheckDuration.WithLabelValues(g.account))
 	defer timer.ObserveDuration()
 
 	gardenaApiUp := 0
 	gardenaApiHealthUrl := g.api.GetAPIHealthURL()
-	if up := checkHealth(gardenaApiHealthUrl); up {
+	if up := checkHealth(ctx, gardenaApiHealthUrl); up {
 		gardenaApiUp = 1
 	}
-	hostHealth.WithLabelValues("api", gardenaApiHealthUrl).Set(float64(gardenaApiUp))
+	hostHealth.WithLabelValues(g.account, "api", gardenaApiHealthUrl).Set(float64(gardenaApiUp))
 
 	if g.gatewayIP != EmptyGatewayIP {
 		gardenaGatewayUp := 0
 		gatewayUrl := "http://" + g.gatewayIP
-		if up := checkHealth(gatewayUrl); up {
+		if up := checkHealth(ctx, gatewayUrl); up {
 			gardenaGatewayUp = 1
 		}
-		hostHealth.WithLabelValues("gateway", gatewayUrl).Set(float64(gardenaGatewayUp))
+		hostHealth.WithLabelValues(g.account, "gateway", gatewayUrl).Set(float64(gardenaGatewayUp))
 	}
 }
 
 // checkHealth performs an HTTP GET request against a given endpoint url. If the request fails or
 // the status code isn't '200' the endpoint is considered unhealthy.
-func checkHealth(url string) bool {
-	resp, err := http.Get(url)
+func checkHealth(ctx context.Context, url string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		log.Printf("Unable to setup request for endpoint '%s'! Err was '%v'\n", url, err)
+		return false
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		log.Printf("Received error querying endpoint '%s'! Err was '%v'\n", url, err)
 		return false
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
 		log.Printf("Received status code '%v' of endpoint '%s'!", resp.StatusCode, url)
 		return false
@@ -0,0 +1,204 @@
+package metric
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena/device"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena/fake"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCountMowerErrors(t *testing.T) {
+	store := storeFromFile(t, "../../test/location.json")
+	store.OnChange(countMowerErrors(DefaultAccount))
+	store.OnRemove(deleteMowerErrors(DefaultAccount))
+	counter := mowerErrors.WithLabelValues(DefaultAccount, "dev-2-id", "SILENO", device.TypeMower, "GARDENA smart Garden", "TRAPPED")
+
+	mowerError := func(code string, ts time.Time) {
+		_, err := store.Apply(gardena.Location{Id: "location-1-id"}, gardena.Device{
+			Id:   "dev-2-id",
+			Type: device.TypeMower,
+			Attributes: map[string]gardena.Attribute{
+				device.AttrLastErrorCode: {Value: code, Timestamp: ts.Format(time.RFC3339)},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Unable to apply update, got err:\n%v", err)
+		}
+	}
+
+	first := time.Date(2023, 6, 10, 12, 0, 0, 0, time.UTC)
+	mowerError("TRAPPED", first)
+	if v := testutil.ToFloat64(counter); v != 1 {
+		t.Fatalf("Expected 1 error after the mower got trapped, got %v", v)
+	}
+
+	// the same error reported again, e.g. with a new battery level, isn't counted twice
+	mowerError("TRAPPED", first)
+	if v := testutil.ToFloat64(counter); v != 1 {
+		t.Fatalf("Expected error to be counted once, got %v", v)
+	}
+
+	mowerError("NO_MESSAGE", first.Add(time.Hour))
+	mowerError("TRAPPED", first.Add(2*time.Hour))
+	if v := testutil.ToFloat64(counter); v != 2 {
+		t.Fatalf("Expected 2 errors after the mower got trapped again, got %v", v)
+	}
+
+	// the errors of removed devices are deleted
+	store.RetainLocations(nil)
+	if v := testutil.ToFloat64(mowerErrors.WithLabelValues(DefaultAccount, "dev-2-id", "SILENO", device.TypeMower, "GARDENA smart Garden", "TRAPPED")); v != 0 {
+		t.Fatalf("Expected errors of removed mower to be deleted, got %v", v)
+	}
+}
+
+func TestErrorReason(t *testing.T) {
+	tests := map[error]string{
+		fmt.Errorf("unable to get locations, got err:\n%w", &gardena.StatusError{StatusCode: 401}): "unauthorized",
+		&gardena.StatusError{StatusCode: 404}:                                                      "not_found",
+		&gardena.StatusError{StatusCode: 503}:                                                      "server_error",
+		fmt.Errorf("%w, used 700 requests", gardena.ErrBudgetExhausted):                            "budget_exhausted",
+		&gardena.DecodeError{Err: errors.New("unexpected end of JSON input")}:                      "decode",
+		context.Canceled:                 "canceled",
+		errors.New("connection refused"): "other",
+	}
+	for err, expected := range tests {
+		if reason := errorReason(err); reason != expected {
+			t.Fatalf("Expected reason %s for '%v', got %s", expected, err, reason)
+		}
+	}
+}
+
+func TestRefreshState(t *testing.T) {
+	client, err := fake.NewClientFromFiles("../../test/location.json", "../../test/location_valves.json")
+	if err != nil {
+		t.Fatalf("Unable to create fake client, got err:\n%v", err)
+	}
+	g := NewGenerator(client, EmptyGatewayIP)
+	ctx := context.Background()
+
+	if err := g.RefreshState(ctx); err != nil {
+		t.Fatalf("Unable to refresh state, got err:\n%v", err)
+	}
+	if n := len(g.Store().Entries()); n != 5 {
+		t.Fatalf("Expected 5 devices of both locations, got %d", n)
+	}
+
+	client.RemoveLocation("location-2-id")
+	if err := g.RefreshState(ctx); err != nil {
+		t.Fatalf("Unable to refresh state, got err:\n%v", err)
+	}
+	if n := len(g.Store().Entries()); n != 2 {
+		t.Fatalf("Expected devices of removed location to be removed, got %d devices", n)
+	}
+
+	// failed refreshes keep the devices and are counted by reason
+	failures := testutil.ToFloat64(stateRefreshErrors.WithLabelValues(DefaultAccount, "server_error"))
+	client.SetError(&gardena.StatusError{StatusCode: 503})
+	if err := g.RefreshState(ctx); !errors.Is(err, gardena.ErrServerError) {
+		t.Fatalf("Expected server error, got %v", err)
+	}
+	if n := len(g.Store().Entries()); n != 2 {
+		t.Fatalf("Expected devices to be kept after failed refresh, got %d devices", n)
+	}
+	if f := testutil.ToFloat64(stateRefreshErrors.WithLabelValues(DefaultAccount, "server_error")); f != failures+1 {
+		t.Fatalf("Expected failed refresh to be counted, got %v", f)
+	}
+}
+
+func TestAccountStarted(t *testing.T) {
+	AccountStarted("back-yard", fmt.Errorf("authentication failed"))
+	AccountStarted("back-yard", fmt.Errorf("authentication failed"))
+	if up, errs := testutil.ToFloat64(accountUp.WithLabelValues("back-yard")), testutil.ToFloat64(accountStartErrors.WithLabelValues("back-yard")); up != 0 || errs != 2 {
+		t.Fatalf("Expected account down after 2 errors, got up %v and %v errors", up, errs)
+	}
+	AccountStarted("back-yard", nil)
+	if up := testutil.ToFloat64(accountUp.WithLabelValues("back-yard")); up != 1 {
+		t.Fatalf("Expected account up, got %v", up)
+	}
+}
+
+func TestRegisterAccounts(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	for account, fixture := range map[string]string{
+		"garden": "../../test/location.json",
+		"yard":   "../../test/location_valves.json",
+	} {
+		client, err := fake.NewClientFromFiles(fixture)
+		if err != nil {
+			t.Fatalf("Unable to create fake client, got err:\n%v", err)
+		}
+		g := NewAccountGenerator(account, client, EmptyGatewayIP)
+		if err := g.Register(reg); err != nil {
+			t.Fatalf("Unable to register generator of account %s, got err:\n%v", account, err)
+		}
+		if err := g.RefreshState(context.Background()); err != nil {
+			t.Fatalf("Unable to refresh state of account %s, got err:\n%v", account, err)
+		}
+	}
+
+	expected := `
+# HELP gardena_smart_system_device_battery_level_percent The battery level of a device in percent
+# TYPE gardena_smart_system_device_battery_level_percent gauge
+gardena_smart_system_device_battery_level_percent{account="garden",id="dev-1-id",location="GARDENA smart Garden",name="Sensor01",type="SENSOR"} 100
+gardena_smart_system_device_battery_level_percent{account="garden",id="dev-2-id",location="GARDENA smart Garden",name="SILENO",type="MOWER"} 100
+# HELP gardena_smart_system_valve_open Indicates if a valve is open and watering
+# TYPE gardena_smart_system_valve_open gauge
+gardena_smart_system_valve_open{account="yard",id="dev-3-id:1",location="Backyard",name="Lawn",type="VALVE"} 1
+gardena_smart_system_valve_open{account="yard",id="dev-3-id:2",location="Backyard",name="Beds",type="VALVE"} 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"gardena_smart_system_device_battery_level_percent",
+		"gardena_smart_system_valve_open"); err != nil {
+		t.Fatalf("Unexpected metrics:\n%v", err)
+	}
+	if n := testutil.ToFloat64(locationsTotal.WithLabelValues("yard", fake.DefaultBaseURL)); n != 1 {
+		t.Fatalf("Expected 1 location of account yard, got %v", n)
+	}
+}
+
+// expiringClient is a fake client with an access token, so its token expiry is registered
+type expiringClient struct {
+	*fake.Client
+}
+
+func (expiringClient) TokenExpiry() time.Time {
+	return time.Date(2023, 6, 10, 12, 0, 0, 0, time.UTC)
+}
+
+func TestRegisterRetry(t *testing.T) {
+	client, err := fake.NewClientFromFiles("../../test/location.json")
+	if err != nil {
+		t.Fatalf("Unable to create fake client, got err:\n%v", err)
+	}
+	reg := prometheus.NewPedanticRegistry()
+	// a conflicting token expiry metric fails the registration after the device collector was registered
+	conflict := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   metricNameSpace,
+		Name:        "api_token_expiry_timestamp_seconds",
+		Help:        "The time the access token of the api expires as unix timestamp",
+		ConstLabels: prometheus.Labels{accountLabel: "retry"},
+	})
+	reg.MustRegister(conflict)
+
+	g := NewAccountGenerator("retry", expiringClient{client}, EmptyGatewayIP)
+	if err := g.Register(reg); err == nil || !strings.Contains(err.Error(), "token expiry") {
+		t.Fatalf("Expected registration of token expiry to fail, got err:\n%v", err)
+	}
+	reg.Unregister(conflict)
+	if err := g.Register(reg); err != nil {
+		t.Fatalf("Expected retried registration to succeed, got err:\n%v", err)
+	}
+	if err := g.RefreshState(context.Background()); err != nil {
+		t.Fatalf("Unable to refresh state, got err:\n%v", err)
+	}
+	if n, err := testutil.GatherAndCount(reg, "gardena_smart_system_api_token_expiry_timestamp_seconds", "gardena_smart_system_device_battery_level_percent"); err != nil || n != 3 {
+		t.Fatalf("Expected token expiry and battery levels of 2 devices, got %d, err %v", n, err)
+	}
+}
@@ -0,0 +1,60 @@
+package metric
+
+import (
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// rateLimitCollector is a prometheus.Collector exporting the client side rate limiting of the gardena api
+type rateLimitCollector struct {
+	status func() gardena.RateLimitStatus
+
+	budgetLimit     *prometheus.Desc
+	budgetRemaining *prometheus.Desc
+	budgetResets    *prometheus.Desc
+	throttled       *prometheus.Desc
+	rejected        *prometheus.Desc
+	blockedUntil    *prometheus.Desc
+}
+
+// newRateLimitCollector creates a rateLimitCollector exporting the status returned by the given function
+func newRateLimitCollector(status func() gardena.RateLimitStatus) *rateLimitCollector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metricNameSpace, "api", name), help, labels, nil)
+	}
+	return &rateLimitCollector{
+		status:          status,
+		budgetLimit:     desc("request_budget_limit", "The number of requests allowed per period of a request budget", "period"),
+		budgetRemaining: desc("request_budget_remaining", "The number of requests left in the current period of a request budget", "period"),
+		budgetResets:    desc("request_budget_reset_timestamp_seconds", "The time the current period of a request budget ends as unix timestamp", "period"),
+		throttled:       desc("throttled_total", "The number of requests answered with 429 Too Many Requests"),
+		rejected:        desc("requests_rejected_total", "The number of requests not sent because a request budget was exhausted"),
+		blockedUntil:    desc("blocked_until_timestamp_seconds", "The time requests are held back until after being throttled as unix timestamp"),
+	}
+}
+
+func (c *rateLimitCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.budgetLimit
+	ch <- c.budgetRemaining
+	ch <- c.budgetResets
+	ch <- c.throttled
+	ch <- c.rejected
+	ch <- c.blockedUntil
+}
+
+func (c *rateLimitCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.status()
+	for _, b := range s.Budgets {
+		period := b.Period.String()
+		ch <- prometheus.MustNewConstMetric(c.budgetLimit, prometheus.GaugeValue, float64(b.Limit), period)
+		ch <- prometheus.MustNewConstMetric(c.budgetRemaining, prometheus.GaugeValue, float64(b.Remaining), period)
+		if !b.ResetsAt.IsZero() {
+			ch <- prometheus.MustNewConstMetric(c.budgetResets, prometheus.GaugeValue, float64(b.ResetsAt.UnixMilli())/1000, period)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(c.throttled, prometheus.CounterValue, float64(s.Throttled))
+	ch <- prometheus.MustNewConstMetric(c.rejected, prometheus.CounterValue, float64(s.Rejected))
+	if !s.BlockedUntil.IsZero() {
+		ch <- prometheus.MustNewConstMetric(c.blockedUntil, prometheus.GaugeValue, float64(s.BlockedUntil.UnixMilli())/1000)
+	}
+}
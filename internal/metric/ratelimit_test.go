@@ -0,0 +1,43 @@
+package metric
+
+import (
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimitCollector(t *testing.T) {
+	c := newRateLimitCollector(func() gardena.RateLimitStatus {
+		return gardena.RateLimitStatus{
+			Budgets: []gardena.BudgetStatus{
+				{Limit: 700, Period: 7 * 24 * time.Hour, Remaining: 512, ResetsAt: time.Unix(1686245982, 0)},
+				{Limit: 100, Period: 24 * time.Hour, Remaining: 100},
+			},
+			Throttled: 3,
+		}
+	})
+
+	expected := `
+# HELP gardena_smart_system_api_request_budget_remaining The number of requests left in the current period of a request budget
+# TYPE gardena_smart_system_api_request_budget_remaining gauge
+gardena_smart_system_api_request_budget_remaining{period="168h0m0s"} 512
+gardena_smart_system_api_request_budget_remaining{period="24h0m0s"} 100
+# HELP gardena_smart_system_api_request_budget_reset_timestamp_seconds The time the current period of a request budget ends as unix timestamp
+# TYPE gardena_smart_system_api_request_budget_reset_timestamp_seconds gauge
+gardena_smart_system_api_request_budget_reset_timestamp_seconds{period="168h0m0s"} 1.686245982e+09
+# HELP gardena_smart_system_api_throttled_total The number of requests answered with 429 Too Many Requests
+# TYPE gardena_smart_system_api_throttled_total counter
+gardena_smart_system_api_throttled_total 3
+`
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"gardena_smart_system_api_request_budget_remaining",
+		"gardena_smart_system_api_request_budget_reset_timestamp_seconds",
+		"gardena_smart_system_api_throttled_total",
+		// not throttled at the moment
+		"gardena_smart_system_api_blocked_until_timestamp_seconds")
+	if err != nil {
+		t.Fatalf("Unexpected metrics:\n%v", err)
+	}
+}
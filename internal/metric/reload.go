@@ -0,0 +1,48 @@
+package metric
+
+import (
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// credentialReloadCollector is a prometheus.Collector exporting the reloads of rotated credentials of the gardena api
+type credentialReloadCollector struct {
+	status func() gardena.CredentialReloadStatus
+
+	reloads     *prometheus.Desc
+	success     *prometheus.Desc
+	lastSuccess *prometheus.Desc
+}
+
+// newCredentialReloadCollector creates a credentialReloadCollector exporting the status returned by the given function
+func newCredentialReloadCollector(status func() gardena.CredentialReloadStatus) *credentialReloadCollector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metricNameSpace, "api", name), help, labels, nil)
+	}
+	return &credentialReloadCollector{
+		status:      status,
+		reloads:     desc("credential_reloads_total", "The number of reloads of changed credentials by result", "result"),
+		success:     desc("credential_reload_success", "Whether the last check for changed credentials succeeded, 1 if there was none yet"),
+		lastSuccess: desc("credential_reload_success_timestamp_seconds", "The time of the last successful reload of changed credentials as unix timestamp"),
+	}
+}
+
+func (c *credentialReloadCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.reloads
+	ch <- c.success
+	ch <- c.lastSuccess
+}
+
+func (c *credentialReloadCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.status()
+	ch <- prometheus.MustNewConstMetric(c.reloads, prometheus.CounterValue, float64(s.Succeeded), "success")
+	ch <- prometheus.MustNewConstMetric(c.reloads, prometheus.CounterValue, float64(s.Failed), "failure")
+	success := 0.0
+	if s.LastSucceeded {
+		success = 1
+	}
+	ch <- prometheus.MustNewConstMetric(c.success, prometheus.GaugeValue, success)
+	if !s.LastSuccessAt.IsZero() {
+		ch <- prometheus.MustNewConstMetric(c.lastSuccess, prometheus.GaugeValue, float64(s.LastSuccessAt.UnixMilli())/1000)
+	}
+}
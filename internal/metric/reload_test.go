@@ -0,0 +1,31 @@
+package metric
+
+import (
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCredentialReloadCollector(t *testing.T) {
+	c := newCredentialReloadCollector(func() gardena.CredentialReloadStatus {
+		return gardena.CredentialReloadStatus{Succeeded: 2, Failed: 1, LastSuccessAt: time.Unix(1686245982, 0)}
+	})
+
+	expected := `
+# HELP gardena_smart_system_api_credential_reload_success Whether the last check for changed credentials succeeded, 1 if there was none yet
+# TYPE gardena_smart_system_api_credential_reload_success gauge
+gardena_smart_system_api_credential_reload_success 0
+# HELP gardena_smart_system_api_credential_reload_success_timestamp_seconds The time of the last successful reload of changed credentials as unix timestamp
+# TYPE gardena_smart_system_api_credential_reload_success_timestamp_seconds gauge
+gardena_smart_system_api_credential_reload_success_timestamp_seconds 1.686245982e+09
+# HELP gardena_smart_system_api_credential_reloads_total The number of reloads of changed credentials by result
+# TYPE gardena_smart_system_api_credential_reloads_total counter
+gardena_smart_system_api_credential_reloads_total{result="failure"} 1
+gardena_smart_system_api_credential_reloads_total{result="success"} 2
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected)); err != nil {
+		t.Fatalf("Unexpected metrics:\n%v", err)
+	}
+}
@@ -1,31 +1,81 @@
 package metric
 
 import (
+	"errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 const metricNameSpace = "gardena_smart_system"
 
+// accountLabel is the label every metric carries with the name of the account it belongs to
+const accountLabel = "account"
+
 var (
 	endpointHealthCheckDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
 		Namespace: metricNameSpace,
 		Name:      "endpoint_health_duration",
 		Help:      "The duration all endpoint health checks took",
-	}, []string{})
+	}, []string{accountLabel})
 	hostHealth = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: metricNameSpace,
 		Name:      "endpoint_health",
 		Help:      "Indicates if a endpoint is healthy",
 	}, []string{
+		accountLabel,
 		"endpoint",
 		"addr",
 	})
+	stateRefreshDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricNameSpace,
+		Name:      "state_refresh_duration",
+		Help:      "The duration a refresh of the state of all locations took",
+	}, []string{accountLabel})
+	stateRefreshErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricNameSpace,
+		Name:      "state_refresh_errors_total",
+		Help:      "The number of failed refreshes of the state of all locations by reason, e.g. unauthorized or server_error",
+	}, []string{accountLabel, "reason"})
+	realtimeConnected = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricNameSpace,
+		Name:      "realtime_connected",
+		Help:      "Indicates if the realtime websocket of a location is connected",
+	}, []string{
+		accountLabel,
+		"location",
+	})
+	realtimeEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricNameSpace,
+		Name:      "realtime_events_total",
+		Help:      "The number of received realtime updates",
+	}, []string{
+		accountLabel,
+		"type",
+	})
+	mowerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricNameSpace,
+		Name:      "mower_errors_total",
+		Help:      "The number of errors reported by a mower since the exporter started",
+	}, append(append([]string{accountLabel}, deviceLabels...), "error_code"))
 	locationsTotal = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: metricNameSpace,
 		Name:      "locations_total",
 		Help:      "The number of locations",
 	}, []string{
+		accountLabel,
 		"endpoint",
 	})
+	accountUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricNameSpace,
+		Name:      "account_up",
+		Help:      "Indicates if an account was started, i.e. authenticated with the api",
+	}, []string{accountLabel})
+	accountStartErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricNameSpace,
+		Name:      "account_start_errors_total",
+		Help:      "The number of failed attempts to start an account",
+	}, []string{accountLabel})
 )
+
+// errUnsupported is returned by deviceMetric values for devices a metric doesn't apply to
+var errUnsupported = errors.New("metric not supported by device")
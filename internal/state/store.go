@@ -1,68 +1,429 @@
 package state
 
 import (
+	"errors"
 	"fmt"
 	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena"
 	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena/device"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 )
 
+const typeLocation = "LOCATION"
+
+// Store holds the devices of all locations. A device is stored per service, e.g. a MOWER,
+// a VALVE_SET or a single VALVE, with the service id as key. The attributes of the COMMON
+// service are shared by all services of the same physical device, but the attributes of
+// different services are never merged.
 type Store struct {
-	devices map[string]device.Device
+	mu        sync.RWMutex
+	devices   map[string]device.Device
+	services  map[string]attributes
+	commons   map[string]attributes
+	locations map[string]locationRef
+	listeners []ChangeListener
+	removed   []RemoveListener
+}
+
+// ChangeListener is called for every device that was added or updated with the previous
+// version of the device, which is nil for new devices, and the entry of the new version.
+type ChangeListener func(old device.Device, e Entry)
+
+// RemoveListener is called with the id of every device that was removed from the store
+type RemoveListener func(id string)
+
+// change is a pending call of the change listeners
+type change struct {
+	old device.Device
+	new Entry
 }
 
-// NewStore creates a new map[string]device.Device
-func NewStore() Store {
+// attributes are the attributes of a service with the attribute name as key
+type attributes map[string]gardena.Attribute
+
+// locationRef identifies the location a device belongs to
+type locationRef struct {
+	id   string
+	name string
+}
+
+// Entry is a stored device together with the name of the location it belongs to
+type Entry struct {
+	Device   device.Device
+	Location string
+}
+
+// Changes lists the ids of all devices that were added, updated or removed
+// while reconciling the store with a location state.
+type Changes struct {
+	Added   []string
+	Updated []string
+	Removed []string
+}
+
+// Empty returns true if nothing changed
+func (c Changes) Empty() bool {
+	return len(c.Added) == 0 && len(c.Updated) == 0 && len(c.Removed) == 0
+}
+
+// NewStore creates a new, empty Store
+func NewStore() *Store {
 	var s Store
 	s.devices = make(map[string]device.Device)
-	return s
+	s.services = make(map[string]attributes)
+	s.commons = make(map[string]attributes)
+	s.locations = make(map[string]locationRef)
+	return &s
+}
+
+// OnChange adds a listener that is called for every added or updated device. Listeners are
+// called after the store is unlocked, so they may query the store.
+func (s *Store) OnChange(l ChangeListener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, l)
+}
+
+// OnRemove adds a listener that is called for every removed device. Listeners are called after
+// the store is unlocked, so they may query the store.
+func (s *Store) OnRemove(l RemoveListener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removed = append(s.removed, l)
+}
+
+// notifyRemoved calls all remove listeners for the given ids. The caller must not hold the lock.
+func (s *Store) notifyRemoved(ids []string) {
+	s.mu.RLock()
+	listeners := s.removed
+	s.mu.RUnlock()
+	for _, id := range ids {
+		for _, l := range listeners {
+			l(id)
+		}
+	}
+}
+
+// notify calls all listeners for the given changes. The caller must not hold the lock.
+func (s *Store) notify(changes []change) {
+	s.mu.RLock()
+	listeners := s.listeners
+	s.mu.RUnlock()
+	for _, c := range changes {
+		for _, l := range listeners {
+			l(c.old, c.new)
+		}
+	}
 }
 
-// StoreDevices adds all devices for a give location state to the store
+// StoreDevices adds all devices for a give location state to the store.
+// Devices already in the store are replaced, see Reconcile.
 func (s *Store) StoreDevices(location gardena.State) error {
-	devices := s.devicesFrom(location)
-	for k, v := range devices {
-		if err := s.addDevice(k, v); err != nil {
-			return fmt.Errorf("Unable to add all devices to internal store for location %s, got err\n%v", location.Data.Id, err)
+	_, err := s.Reconcile(location)
+	return err
+}
+
+// Reconcile brings the devices of a location in line with the given location state. Devices
+// that are new to the store are added, known devices are replaced and devices of the location
+// that are missing in the state are removed. Services of unsupported types are skipped. If any
+// other device of the state can't be created, the store is left untouched.
+func (s *Store) Reconcile(location gardena.State) (Changes, error) {
+	loc := locationRef{id: location.Data.Id, name: location.Data.Attributes.Name}
+	services, commons := s.servicesFrom(location)
+	devices := make(map[string]device.Device)
+	for id := range services {
+		d, err := device.Factory(attrsOf(id, services, commons))
+		if errors.Is(err, device.ErrUnsupportedType) {
+			log.Printf("Skipping service %s of location %s: %v", id, loc.id, err)
+			delete(services, id)
+			continue
+		}
+		if err != nil {
+			return Changes{}, fmt.Errorf("unable to create device with id %s for location %s with factory, got err:\n%w", id, loc.id, err)
+		}
+		devices[id] = d
+	}
+
+	// deferred first, so the listeners are notified after unlocking
+	var pending []change
+	var c Changes
+	defer func() {
+		s.notify(pending)
+		s.notifyRemoved(c.Removed)
+	}()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, d := range devices {
+		old, ok := s.devices[id]
+		switch {
+		case !ok:
+			c.Added = append(c.Added, id)
+			pending = append(pending, change{old: old, new: Entry{Device: d, Location: loc.name}})
+		case !reflect.DeepEqual(old, d) || s.locations[id] != loc:
+			c.Updated = append(c.Updated, id)
+			pending = append(pending, change{old: old, new: Entry{Device: d, Location: loc.name}})
+		}
+		logSchemaDrift(old, d)
+		s.devices[id] = d
+		s.services[id] = services[id]
+		s.locations[id] = loc
+	}
+	for id, attrs := range commons {
+		s.commons[id] = attrs
+	}
+	for id, l := range s.locations {
+		if _, ok := devices[id]; !ok && l.id == loc.id {
+			if s.devices[id] != nil {
+				c.Removed = append(c.Removed, id)
+			}
+			s.removeDevice(id)
+		}
+	}
+	s.pruneCommons()
+	sort.Strings(c.Added)
+	sort.Strings(c.Updated)
+	sort.Strings(c.Removed)
+	return c, nil
+}
+
+// Apply merges the attributes of a single service update, as received from the realtime api,
+// into the stored devices. An update of a COMMON service applies to all services of its device.
+// Unknown services are added to the given location as soon as enough attributes are known to
+// create them. It reports whether a device was changed. Services failing to rebuild keep their
+// previous state, their errors are joined, while the other services of the device are updated.
+// Updates of DEVICE or LOCATION objects carry no attributes and are ignored.
+func (s *Store) Apply(location gardena.Location, update gardena.Device) (bool, error) {
+	if update.Type == device.Type || update.Type == typeLocation {
+		return false, nil
+	}
+
+	// deferred first, so the listeners are notified after unlocking
+	var pending []change
+	defer func() { s.notify(pending) }()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var attrs attributes
+	if update.Type == device.CommonType {
+		attrs = merge(s.commons[update.Id])
+	} else {
+		attrs = merge(s.services[update.Id])
+		attrs[device.AttrId] = gardena.Attribute{Value: update.Id}
+		attrs[device.AttrType] = gardena.Attribute{Value: update.Type}
+	}
+	for k, v := range update.Attributes {
+		attrs[k] = v
+	}
+
+	if update.Type == device.CommonType {
+		s.commons[update.Id] = attrs
+	} else {
+		s.services[update.Id] = attrs
+		if _, ok := s.locations[update.Id]; !ok {
+			s.locations[update.Id] = locationRef{id: location.Id, name: location.Attributes.Name}
+		}
+	}
+
+	// all services of the device are rebuild, since they share the COMMON attributes
+	// and new or removed valves change the valve ids of their valve set
+	// a service failing to rebuild doesn't keep the others from being updated
+	changed := false
+	var errs []error
+	for _, id := range s.serviceIdsOf(deviceIdOf(update.Id)) {
+		d, err := device.Factory(attrsOf(id, s.services, s.commons))
+		if err != nil {
+			if s.devices[id] != nil {
+				// otherwise not yet complete, wait for further updates
+				errs = append(errs, fmt.Errorf("unable to apply update to device with id %s, got err:\n%w", id, err))
+			}
+			continue
+		}
+		if !reflect.DeepEqual(s.devices[id], d) {
+			changed = true
+			pending = append(pending, change{old: s.devices[id], new: Entry{Device: d, Location: s.locations[id].name}})
 		}
+		logSchemaDrift(s.devices[id], d)
+		s.devices[id] = d
 	}
-	return nil
+	return changed, errors.Join(errs...)
 }
 
-// addDevice adds a given id/map of attributes to the store.
-// It uses the factory method of gardena.device to create an
-// actual device (MOWER, SENSOR, ...) from the input.
-// If a device with the given id is already in the store
-// an error is returned.
-func (s *Store) addDevice(id string, attrs map[string]any) error {
-	if s.devices[id] != nil {
-		return fmt.Errorf("device with id %s already in store", id)
+// RetainLocations removes all devices that don't belong to one of the given location ids.
+// The ids of the removed devices are returned.
+func (s *Store) RetainLocations(locationIds []string) []string {
+	keep := make(map[string]bool, len(locationIds))
+	for _, id := range locationIds {
+		keep[id] = true
 	}
-	d, err := device.Factory(attrs)
-	if err != nil {
-		return fmt.Errorf("unable to create device with device with id %s with factory, got err:\n%w", id, err)
+
+	var removed []string
+	// deferred first, so the listeners are notified after unlocking
+	defer func() { s.notifyRemoved(removed) }()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, l := range s.locations {
+		if !keep[l.id] {
+			if s.devices[id] != nil {
+				removed = append(removed, id)
+			}
+			s.removeDevice(id)
+		}
 	}
-	s.devices[id] = d
-	return nil
+	s.pruneCommons()
+	sort.Strings(removed)
+	return removed
 }
 
-// devicesFrom generates a map of devices by merging DEVICE, <type> and COMMON into on map of
-// attributes with the attribute name as key. The attribute value is either a str or a float.
-// Those devices are collected in a map with the device ID as key.
-func (s *Store) devicesFrom(locationData gardena.State) map[string]map[string]any {
-	devices := make(map[string]map[string]any)
-	for _, d := range locationData.Included {
-		m := devices[d.Id]
-		if m == nil {
-			m = make(map[string]any)
+// Get returns the device with the given id and the name of its location
+func (s *Store) Get(id string) (Entry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d, ok := s.devices[id]
+	if !ok {
+		return Entry{}, false
+	}
+	return Entry{Device: d, Location: s.locations[id].name}, true
+}
+
+// Entries returns a snapshot of all devices in the store, sorted by device id.
+func (s *Store) Entries() []Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := make([]Entry, 0, len(s.devices))
+	for id, d := range s.devices {
+		entries = append(entries, Entry{Device: d, Location: s.locations[id].name})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Device.GetDeviceId() < entries[j].Device.GetDeviceId()
+	})
+	return entries
+}
+
+// removeDevice deletes the device with the given id. The caller has to hold the lock.
+func (s *Store) removeDevice(id string) {
+	delete(s.devices, id)
+	delete(s.services, id)
+	delete(s.locations, id)
+}
+
+// pruneCommons deletes the COMMON attributes of devices without any service left.
+// The caller has to hold the lock.
+func (s *Store) pruneCommons() {
+	for id := range s.commons {
+		if len(s.serviceIdsOf(id)) == 0 {
+			delete(s.commons, id)
+		}
+	}
+}
+
+// serviceIdsOf returns the ids of all stored services of the device with the given id.
+// The caller has to hold the lock.
+func (s *Store) serviceIdsOf(deviceId string) []string {
+	var ids []string
+	for id := range s.services {
+		if deviceIdOf(id) == deviceId {
+			ids = append(ids, id)
 		}
-		m[device.AttrId] = d.Id
-		if d.Type != device.CommonType && d.Type != device.Type {
-			m[device.AttrType] = d.Type
+	}
+	return ids
+}
+
+// servicesFrom splits the objects of a location state into the attributes of each service,
+// e.g. MOWER, SENSOR or VALVE, with the service id as key and the attributes of each COMMON
+// service with the device id as key. Each attribute keeps its value and timestamp.
+// DEVICE objects carry no attributes and are skipped.
+func (s *Store) servicesFrom(locationData gardena.State) (map[string]attributes, map[string]attributes) {
+	services := make(map[string]attributes)
+	commons := make(map[string]attributes)
+	for _, d := range locationData.Included {
+		var m attributes
+		switch d.Type {
+		case device.Type:
+			continue
+		case device.CommonType:
+			if commons[d.Id] == nil {
+				commons[d.Id] = make(attributes)
+			}
+			m = commons[d.Id]
+		default:
+			if services[d.Id] == nil {
+				services[d.Id] = make(attributes)
+			}
+			m = services[d.Id]
+			m[device.AttrId] = gardena.Attribute{Value: d.Id}
+			m[device.AttrType] = gardena.Attribute{Value: d.Type}
 		}
 		for k, v := range d.Attributes {
-			m[k] = v.Value
+			m[k] = v
 		}
-		devices[d.Id] = m
 	}
-	return devices
+	return services, commons
+}
+
+// logSchemaDrift logs the warnings of a device, if they differ from the warnings of the
+// previous version of the device, so that each drift of the api schema is only logged once.
+func logSchemaDrift(old, d device.Device) {
+	w := d.GetWarnings()
+	if len(w) == 0 || old != nil && reflect.DeepEqual(old.GetWarnings(), w) {
+		return
+	}
+	log.Printf("Device %s of type %s reported unexpected attributes: %v", d.GetDeviceId(), d.GetDeviceType(), w)
+}
+
+// attrsOf merges the COMMON attributes of the device a service belongs to with the attributes of
+// the service itself and converts them to the input of device.Factory. Valves and the valve set of
+// the same device are linked by adding the id of the valve set to each valve and the ids of all
+// valves to the valve set.
+func attrsOf(id string, services, commons map[string]attributes) map[string]any {
+	attrs := merge(commons[deviceIdOf(id)], services[id])
+	switch attrs[device.AttrType].Value {
+	case device.TypeValve:
+		for setId, set := range services {
+			if set[device.AttrType].Value == device.TypeValveSet && deviceIdOf(setId) == deviceIdOf(id) {
+				attrs[device.AttrValveSetId] = gardena.Attribute{Value: setId}
+			}
+		}
+	case device.TypeValveSet:
+		var valveIds []string
+		for valveId, valve := range services {
+			if valve[device.AttrType].Value == device.TypeValve && deviceIdOf(valveId) == deviceIdOf(id) {
+				valveIds = append(valveIds, valveId)
+			}
+		}
+		sort.Strings(valveIds)
+		attrs[device.AttrValveIds] = gardena.Attribute{Value: valveIds}
+	}
+
+	in := make(map[string]any, len(attrs)+1)
+	timestamps := make(map[string]string)
+	for k, v := range attrs {
+		in[k] = v.Value
+		if v.Timestamp != "" {
+			timestamps[k] = v.Timestamp
+		}
+	}
+	in[device.AttrTimestamps] = timestamps
+	return in
+}
+
+// merge creates a new map with all attributes of the given maps. Attributes of later maps
+// overwrite the ones of earlier maps, e.g. the name of a VALVE overwrites the COMMON name.
+func merge(in ...attributes) attributes {
+	m := make(attributes)
+	for _, attrs := range in {
+		for k, v := range attrs {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// deviceIdOf returns the id of the device a service belongs to. Services of devices
+// with multiple services of the same type, like valves, have ids of the form
+// '<device id>:<number>'.
+func deviceIdOf(serviceId string) string {
+	id, _, _ := strings.Cut(serviceId, ":")
+	return id
 }
@@ -1,11 +1,16 @@
 package state
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena"
 	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena/device"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestStoreDevicesFromState(t *testing.T) {
@@ -46,6 +51,16 @@ func TestStoreDevicesFromState(t *testing.T) {
 	if soH != 95 {
 		t.Fatalf("Excepted 95 as value for %s, got %v", device.AttrSoilHumidity, soH)
 	}
+	ts, err := sensor.GetAttrTimestamp(device.AttrSoilHumidity)
+	if err != nil {
+		t.Fatalf("Unexcepted error for timestamp of attr %s:\n%v", device.AttrSoilHumidity, err)
+	}
+	if expected := time.Date(2023, 6, 8, 17, 39, 42, 0, time.UTC); !ts.Equal(expected) {
+		t.Fatalf("Excepted %v as timestamp for %s, got %v", expected, device.AttrSoilHumidity, ts)
+	}
+	if _, err := sensor.GetAttrTimestamp(device.AttrName); err == nil {
+		t.Fatalf("Expected error for timestamp of attr %s, which is reported without timestamp", device.AttrName)
+	}
 	n, err := sensor.GetStrAttr(device.AttrName)
 	if err != nil {
 		t.Fatalf("Unexcepted error for attr %s:\n%v", device.AttrName, err)
@@ -90,3 +105,237 @@ func TestStoreDevicesFromState(t *testing.T) {
 		t.Fatalf("Expected empty value and error for float attribute with key 'foo'")
 	}
 }
+
+func TestReconcileLocation(t *testing.T) {
+	location, err := os.ReadFile("../../test/location.json")
+	if err != nil {
+		t.Fatal("Unable to read location.json file", err)
+	}
+	state := gardena.State{}
+	if err := json.Unmarshal(location, &state); err != nil {
+		t.Fatal("Unable to create state from json", err)
+	}
+	s := NewStore()
+	c, err := s.Reconcile(state)
+	if err != nil {
+		t.Fatal("Unable to store state", err)
+	}
+	if !reflect.DeepEqual(c.Added, []string{"dev-1-id", "dev-2-id"}) || len(c.Updated) != 0 || len(c.Removed) != 0 {
+		t.Fatalf("Expected both devices to be added, got %+v", c)
+	}
+
+	// Storing the same state again must not fail and doesn't change anything
+	c, err = s.Reconcile(state)
+	if err != nil {
+		t.Fatal("Unable to store state a second time", err)
+	}
+	if !c.Empty() {
+		t.Fatalf("Expected no changes, got %+v", c)
+	}
+
+	// Drop the mower and change the sensor's humidity
+	var included []gardena.Device
+	for _, d := range state.Included {
+		if d.Id == "dev-2-id" {
+			continue
+		}
+		if d.Type == device.TypeSensor {
+			attrs := make(map[string]gardena.Attribute)
+			for k, v := range d.Attributes {
+				attrs[k] = v
+			}
+			attrs[device.AttrSoilHumidity] = gardena.Attribute{Value: float64(42)}
+			d.Attributes = attrs
+		}
+		included = append(included, d)
+	}
+	state.Included = included
+	c, err = s.Reconcile(state)
+	if err != nil {
+		t.Fatal("Unable to reconcile state", err)
+	}
+	if len(c.Added) != 0 || !reflect.DeepEqual(c.Updated, []string{"dev-1-id"}) || !reflect.DeepEqual(c.Removed, []string{"dev-2-id"}) {
+		t.Fatalf("Expected sensor to be updated and mower to be removed, got %+v", c)
+	}
+	soH, err := s.devices["dev-1-id"].GetFloatAttr(device.AttrSoilHumidity)
+	if err != nil || soH != 42 {
+		t.Fatalf("Expected updated soil humidity of 42, got %v, err %v", soH, err)
+	}
+
+	// Location is gone
+	removed := s.RetainLocations(nil)
+	if !reflect.DeepEqual(removed, []string{"dev-1-id"}) || len(s.Entries()) != 0 {
+		t.Fatalf("Expected all devices to be removed, got %v, remaining %v", removed, s.Entries())
+	}
+}
+
+func TestApplyRealtimeUpdate(t *testing.T) {
+	location, err := os.ReadFile("../../test/location.json")
+	if err != nil {
+		t.Fatal("Unable to read location.json file", err)
+	}
+	state := gardena.State{}
+	if err := json.Unmarshal(location, &state); err != nil {
+		t.Fatal("Unable to create state from json", err)
+	}
+	s := NewStore()
+	if err := s.StoreDevices(state); err != nil {
+		t.Fatal("Unable to store state", err)
+	}
+
+	l := gardena.Location{Id: state.Data.Id}
+	changed, err := s.Apply(l, gardena.Device{
+		Id:         "dev-2-id",
+		Type:       device.TypeMower,
+		Attributes: map[string]gardena.Attribute{device.AttrActivity: {Value: "OK_CUTTING"}},
+	})
+	if err != nil || !changed {
+		t.Fatalf("Expected mower to be changed without error, got changed %v, err %v", changed, err)
+	}
+	a, err := s.devices["dev-2-id"].GetStrAttr(device.AttrActivity)
+	if err != nil || a != "OK_CUTTING" {
+		t.Fatalf("Expected activity OK_CUTTING, got %v, err %v", a, err)
+	}
+	opH, err := s.devices["dev-2-id"].GetFloatAttr(device.AttrOperatingHours)
+	if err != nil || opH != 435 {
+		t.Fatalf("Expected operating hours to be kept, got %v, err %v", opH, err)
+	}
+
+	// Updates of the DEVICE object itself are ignored
+	changed, err = s.Apply(l, gardena.Device{Id: "dev-2-id", Type: device.Type})
+	if err != nil || changed {
+		t.Fatalf("Expected DEVICE update to be ignored, got changed %v, err %v", changed, err)
+	}
+}
+
+func TestMalformedTimestamp(t *testing.T) {
+	location, err := os.ReadFile("../../test/location.json")
+	if err != nil {
+		t.Fatal("Unable to read location.json file", err)
+	}
+	// one malformed timestamp doesn't make the whole location disappear
+	location = bytes.Replace(location, []byte(`"timestamp": "2023-06-08T17:34:01.000+00:00"`), []byte(`"timestamp": "08.06.2023 17:34"`), 1)
+	state := gardena.State{}
+	if err := json.Unmarshal(location, &state); err != nil {
+		t.Fatalf("Expected state with malformed timestamp to be decoded, got err:\n%v", err)
+	}
+	s := NewStore()
+	if err := s.StoreDevices(state); err != nil {
+		t.Fatal("Unable to store state", err)
+	}
+
+	mower := s.devices["dev-2-id"]
+	if _, err := mower.GetAttrTimestamp(device.AttrState); !errors.Is(err, device.ErrAttrNotSet) {
+		t.Fatalf("Expected malformed timestamp to be unset, got err %v", err)
+	}
+	if ts, err := mower.GetAttrTimestamp(device.AttrActivity); err != nil || ts.IsZero() {
+		t.Fatalf("Expected other timestamps to be kept, got %v, err %v", ts, err)
+	}
+	if w := mower.GetWarnings(); len(w) != 1 || !strings.Contains(w[0], "timestamp of attribute 'state' is malformed") {
+		t.Fatalf("Expected warning about the malformed timestamp, got %v", w)
+	}
+}
+
+func TestStoreValvesOfMultiValveDevice(t *testing.T) {
+	location, err := os.ReadFile("../../test/location_valves.json")
+	if err != nil {
+		t.Fatal("Unable to read location_valves.json file", err)
+	}
+	state := gardena.State{}
+	if err := json.Unmarshal(location, &state); err != nil {
+		t.Fatal("Unable to create state from json", err)
+	}
+	s := NewStore()
+	if err := s.StoreDevices(state); err != nil {
+		t.Fatal("Unable to store state", err)
+	}
+	if len(s.devices) != 3 {
+		t.Fatalf("Expected a valve set and two valves, found %d", len(s.devices))
+	}
+
+	set, ok := s.devices["dev-3-id"].(device.ValveSet)
+	if !ok {
+		t.Fatalf("Expected valve set with id dev-3-id, got %v", s.devices["dev-3-id"])
+	}
+	if !reflect.DeepEqual(set.GetValveIds(), []string{"dev-3-id:1", "dev-3-id:2"}) {
+		t.Fatalf("Expected valve set to link both valves, got %v", set.GetValveIds())
+	}
+	st, err := set.GetStrAttr(device.AttrState)
+	if err != nil || st != "WARNING" {
+		t.Fatalf("Expected valve set state WARNING, got %v, err %v", st, err)
+	}
+
+	for id, name := range map[string]string{"dev-3-id:1": "Lawn", "dev-3-id:2": "Beds"} {
+		v := s.devices[id]
+		if v == nil || v.GetDeviceType() != device.TypeValve {
+			t.Fatalf("Expected valve with id %s, got %v", id, v)
+		}
+		n, err := v.GetStrAttr(device.AttrName)
+		if err != nil || n != name {
+			t.Fatalf("Expected valve %s to be named %s, got %v, err %v", id, name, n, err)
+		}
+		serial, err := v.GetStrAttr(device.AttrSerial)
+		if err != nil || serial != "98765" {
+			t.Fatalf("Expected valve %s to have the serial of its device, got %v, err %v", id, serial, err)
+		}
+		// the valve must not inherit state and error of its valve set
+		st, err := v.GetStrAttr(device.AttrState)
+		if err != nil || st != "OK" {
+			t.Fatalf("Expected valve %s to have state OK, got %v, err %v", id, st, err)
+		}
+		if parent := v.(device.Valve).GetValveSetId(); parent != "dev-3-id" {
+			t.Fatalf("Expected valve %s to belong to valve set dev-3-id, got %s", id, parent)
+		}
+	}
+
+	// A COMMON update applies to the valve set and all valves of the device
+	changed, err := s.Apply(gardena.Location{Id: state.Data.Id}, gardena.Device{
+		Id:         "dev-3-id",
+		Type:       device.CommonType,
+		Attributes: map[string]gardena.Attribute{device.AttrRFLinkLevel: {Value: float64(40)}},
+	})
+	if err != nil || !changed {
+		t.Fatalf("Expected valves to be changed without error, got changed %v, err %v", changed, err)
+	}
+	for _, id := range []string{"dev-3-id", "dev-3-id:1", "dev-3-id:2"} {
+		l, err := s.devices[id].GetFloatAttr(device.AttrRFLinkLevel)
+		if err != nil || l != 40 {
+			t.Fatalf("Expected rf link level of 40 for %s, got %v, err %v", id, l, err)
+		}
+	}
+}
+
+func TestApplyPartialFailure(t *testing.T) {
+	location, err := os.ReadFile("../../test/location_valves.json")
+	if err != nil {
+		t.Fatal("Unable to read location_valves.json file", err)
+	}
+	state := gardena.State{}
+	if err := json.Unmarshal(location, &state); err != nil {
+		t.Fatal("Unable to create state from json", err)
+	}
+	s := NewStore()
+	if err := s.StoreDevices(state); err != nil {
+		t.Fatal("Unable to store state", err)
+	}
+	// a service that can't be rebuilt anymore, e.g. after an update changed its type
+	s.services["dev-3-id:1"][device.AttrType] = gardena.Attribute{Value: "SCHEDULER"}
+
+	changed, err := s.Apply(gardena.Location{Id: state.Data.Id}, gardena.Device{
+		Id:         "dev-3-id",
+		Type:       device.CommonType,
+		Attributes: map[string]gardena.Attribute{device.AttrRFLinkLevel: {Value: float64(40)}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "dev-3-id:1") || !errors.Is(err, device.ErrUnsupportedType) {
+		t.Fatalf("Expected error of valve dev-3-id:1, got err:\n%v", err)
+	}
+	if !changed {
+		t.Fatalf("Expected the other services to be changed")
+	}
+	for _, id := range []string{"dev-3-id", "dev-3-id:2"} {
+		l, err := s.devices[id].GetFloatAttr(device.AttrRFLinkLevel)
+		if err != nil || l != 40 {
+			t.Fatalf("Expected rf link level of 40 for %s despite the failed valve, got %v, err %v", id, l, err)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Config is the web config of the exporter, in the format of the web config of the prometheus
+// exporter-toolkit. It's read from its file again for every request and TLS handshake, so changes
+// of users and certificates take effect without restart.
+type Config struct {
+	TLSConfig TLSConfig         `yaml:"tls_server_config"`
+	Users     map[string]string `yaml:"basic_auth_users"`
+}
+
+// TLSConfig configures TLS and the authentication of clients by certificates. TLS is enabled if a
+// certificate is set.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientAuth is the policy of client certificates, e.g. RequireAndVerifyClientCert
+	ClientAuth   string `yaml:"client_auth_type"`
+	ClientCAFile string `yaml:"client_ca_file"`
+	// MinVersion is the minimum TLS version, e.g. TLS13. Defaults to TLS12.
+	MinVersion string `yaml:"min_version"`
+}
+
+// clientAuthTypes are the supported values of TLSConfig.ClientAuth
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                           tls.NoClientCert,
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+// tlsVersions are the supported values of TLSConfig.MinVersion
+var tlsVersions = map[string]uint16{
+	"":      tls.VersionTLS12,
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+// ReadConfig reads and validates the web config file at the given path. Unknown keys are rejected.
+func ReadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read web config, got err:\n%w", err)
+	}
+	var c Config
+	d := yaml.NewDecoder(bytes.NewReader(b))
+	d.KnownFields(true)
+	if err := d.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid web config %s, got err:\n%w", path, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("invalid web config %s, got err:\n%w", path, err)
+	}
+	return &c, nil
+}
+
+// validate checks the config and reports all invalid values at once
+func (c *Config) validate() error {
+	var errs []error
+	t := c.TLSConfig
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		errs = append(errs, fmt.Errorf("tls_server_config: cert_file and key_file have to be set together"))
+	}
+	auth, ok := clientAuthTypes[t.ClientAuth]
+	if !ok {
+		errs = append(errs, fmt.Errorf("tls_server_config: unknown client_auth_type '%s'", t.ClientAuth))
+	}
+	if t.ClientCAFile != "" && (auth == tls.NoClientCert || auth == tls.RequestClientCert || auth == tls.RequireAnyClientCert) {
+		errs = append(errs, fmt.Errorf("tls_server_config: client_ca_file requires client_auth_type VerifyClientCertIfGiven or RequireAndVerifyClientCert"))
+	}
+	if (auth == tls.VerifyClientCertIfGiven || auth == tls.RequireAndVerifyClientCert) && t.ClientCAFile == "" {
+		errs = append(errs, fmt.Errorf("tls_server_config: client_auth_type %s requires client_ca_file", t.ClientAuth))
+	}
+	if t.CertFile == "" && (t.ClientAuth != "" || t.ClientCAFile != "") {
+		errs = append(errs, fmt.Errorf("tls_server_config: client certificates require cert_file and key_file"))
+	}
+	if _, ok := tlsVersions[t.MinVersion]; !ok {
+		errs = append(errs, fmt.Errorf("tls_server_config: unknown min_version '%s'", t.MinVersion))
+	}
+	for user, hash := range c.Users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			errs = append(errs, fmt.Errorf("basic_auth_users: password of user %s is no bcrypt hash", user))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// tlsEnabled reports if the config enables TLS
+func (c *Config) tlsEnabled() bool {
+	return c.TLSConfig.CertFile != ""
+}
+
+// serverTLSConfig loads the certificates of the config and returns the tls.Config of the server
+func (c *Config) serverTLSConfig() (*tls.Config, error) {
+	t := c.TLSConfig
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load certificate, got err:\n%w", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   clientAuthTypes[t.ClientAuth],
+		MinVersion:   tlsVersions[t.MinVersion],
+	}
+	if t.ClientCAFile != "" {
+		pem, err := os.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read client ca, got err:\n%w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("client ca %s contains no certificate", t.ClientCAFile)
+		}
+		cfg.ClientCAs = pool
+	}
+	return cfg, nil
+}
+
+// ListenAndServe serves the handler of the given server as configured by the web config file at
+// the given path. Without path, the server serves plain http without authentication. The config
+// file is read again for every request and TLS handshake. Enabling or disabling TLS requires a
+// restart though, since it changes the listener. Requests of paths with one of the given prefixes
+// skip basic auth, since they authenticate themselves, e.g. the control api by its bearer token.
+func ListenAndServe(srv *http.Server, path string, ownAuth ...string) error {
+	if path == "" {
+		return srv.ListenAndServe()
+	}
+	c, err := ReadConfig(path)
+	if err != nil {
+		return err
+	}
+	srv.Handler = &authHandler{path: path, next: handlerOf(srv), ownAuth: ownAuth}
+	if !c.tlsEnabled() {
+		log.Printf("TLS is disabled in web config %s", path)
+		return srv.ListenAndServe()
+	}
+	if _, err := c.serverTLSConfig(); err != nil {
+		return err
+	}
+	srv.TLSConfig = reloadingTLSConfig(path)
+	log.Printf("TLS is enabled in web config %s", path)
+	return srv.ListenAndServeTLS("", "")
+}
+
+// reloadingTLSConfig returns a tls.Config reading the web config file at the given path for every
+// handshake, so renewed certificates are used without restart
+func reloadingTLSConfig(path string) *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c, err := ReadConfig(path)
+			if err != nil {
+				log.Printf("Unable to reload web config, got err:\n%v", err)
+				return nil, err
+			}
+			if !c.tlsEnabled() {
+				return nil, fmt.Errorf("TLS was disabled in web config %s, restart to serve plain http", path)
+			}
+			return c.serverTLSConfig()
+		},
+	}
+}
+
+func handlerOf(srv *http.Server) http.Handler {
+	if srv.Handler == nil {
+		return http.DefaultServeMux
+	}
+	return srv.Handler
+}
+
+// authHandler authenticates requests with the basic auth users of the web config before passing
+// them to the next handler. Without users, all requests are passed.
+type authHandler struct {
+	path    string
+	next    http.Handler
+	ownAuth []string
+
+	// verified caches successful password checks, since bcrypt is slow by design
+	verified sync.Map
+}
+
+// dummyHash is compared for unknown users, so they take as long as known users with a wrong password
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+
+func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, prefix := range h.ownAuth {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			h.next.ServeHTTP(w, r)
+			return
+		}
+	}
+	c, err := ReadConfig(h.path)
+	if err != nil {
+		log.Printf("Unable to reload web config, got err:\n%v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if len(c.Users) > 0 && !h.authenticated(c, r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="gardena-smart-system-exporter"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// authenticated checks the basic auth credentials of the request against the users of the config
+func (h *authHandler) authenticated(c *Config, r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	hash, known := c.Users[user]
+	if !known {
+		hash = string(dummyHash)
+	}
+	key := sha256.Sum256([]byte(user + "\x00" + hash + "\x00" + password))
+	if _, ok := h.verified.Load(key); ok {
+		return known
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil || !known {
+		return false
+	}
+	h.verified.Store(key, true)
+	return true
+}
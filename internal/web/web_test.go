@@ -0,0 +1,251 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadConfigExample(t *testing.T) {
+	c, err := ReadConfig("../../configs/web-config.example.yaml")
+	if err != nil {
+		t.Fatalf("Unable to read example web config, got err:\n%v", err)
+	}
+	if !c.tlsEnabled() || c.TLSConfig.ClientAuth != "RequireAndVerifyClientCert" || len(c.Users) != 1 {
+		t.Fatalf("Unexpected example web config %+v", c)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(c.Users["prometheus"]), []byte("prometheus")); err != nil {
+		t.Fatalf("Expected documented password, got err:\n%v", err)
+	}
+}
+
+func TestReadConfigInvalid(t *testing.T) {
+	path := writeFile(t, "web.yaml", `
+tls_server_config:
+  cert_file: server.crt
+  client_auth_type: VerifyAlways
+  min_version: TLS14
+basic_auth_users:
+  alice: secret
+`)
+	_, err := ReadConfig(path)
+	if err == nil {
+		t.Fatalf("Expected invalid web config to be rejected")
+	}
+	for _, problem := range []string{
+		"cert_file and key_file have to be set together",
+		"unknown client_auth_type 'VerifyAlways'",
+		"unknown min_version 'TLS14'",
+		"password of user alice is no bcrypt hash",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Fatalf("Expected error to contain '%s', got err:\n%v", problem, err)
+		}
+	}
+
+	if _, err := ReadConfig(writeFile(t, "web.yaml", "basic_auth_user: {}")); err == nil || !strings.Contains(err.Error(), "basic_auth_user") {
+		t.Fatalf("Expected unknown key to be rejected, got err:\n%v", err)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	path := writeFile(t, "web.yaml", users(t, "alice", "<alice-password>"))
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("metrics"))
+	})
+	srv := httptest.NewServer(&authHandler{path: path, next: next, ownAuth: []string{"/control/"}})
+	defer srv.Close()
+
+	for _, c := range []struct {
+		name, path, user, password string
+		expected                   int
+	}{
+		{"valid credentials", "/metrics", "alice", "<alice-password>", http.StatusOK},
+		{"cached credentials", "/metrics", "alice", "<alice-password>", http.StatusOK},
+		{"wrong password", "/metrics", "alice", "<wrong-password>", http.StatusUnauthorized},
+		{"unknown user", "/metrics", "bob", "<alice-password>", http.StatusUnauthorized},
+		{"no credentials", "/metrics", "", "", http.StatusUnauthorized},
+		{"own auth", "/control/v1/locations", "", "", http.StatusOK},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if status := get(t, http.DefaultClient, srv.URL+c.path, c.user, c.password); status != c.expected {
+				t.Fatalf("Expected status %d, got %d", c.expected, status)
+			}
+		})
+	}
+
+	// users are read again for every request
+	if err := os.WriteFile(path, []byte(users(t, "bob", "<bob-password>")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if status := get(t, http.DefaultClient, srv.URL+"/metrics", "alice", "<alice-password>"); status != http.StatusUnauthorized {
+		t.Fatalf("Expected removed user to be rejected, got status %d", status)
+	}
+	if status := get(t, http.DefaultClient, srv.URL+"/metrics", "bob", "<bob-password>"); status != http.StatusOK {
+		t.Fatalf("Expected added user to be accepted, got status %d", status)
+	}
+
+	// without users, all requests are passed
+	if err := os.WriteFile(path, []byte("basic_auth_users: {}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if status := get(t, http.DefaultClient, srv.URL+"/metrics", "", ""); status != http.StatusOK {
+		t.Fatalf("Expected request without users to be passed, got status %d", status)
+	}
+
+	// an invalid config fails closed
+	if err := os.WriteFile(path, []byte("basic_auth_users: [alice]"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if status := get(t, http.DefaultClient, srv.URL+"/metrics", "bob", "<bob-password>"); status != http.StatusInternalServerError {
+		t.Fatalf("Expected invalid web config to fail requests, got status %d", status)
+	}
+}
+
+func TestClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := newCertificate(t, "ca", nil, nil)
+	writePEM(t, filepath.Join(dir, "ca.crt"), ca, caKey)
+	server, serverKey := newCertificate(t, "localhost", ca, caKey)
+	writePEM(t, filepath.Join(dir, "server.crt"), server, serverKey)
+	client, clientKey := newCertificate(t, "prometheus", ca, caKey)
+	writePEM(t, filepath.Join(dir, "client.crt"), client, clientKey)
+
+	path := writeFile(t, "web.yaml", fmt.Sprintf(`
+tls_server_config:
+  cert_file: %[1]s/server.crt
+  key_file: %[1]s/server.key
+  client_auth_type: RequireAndVerifyClientCert
+  client_ca_file: %[1]s/ca.crt
+  min_version: TLS13
+`, dir))
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = reloadingTLSConfig(path)
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	clientCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+	}
+
+	if status := get(t, newClient(clientCert), srv.URL, "", ""); status != http.StatusOK {
+		t.Fatalf("Expected client with certificate to be accepted, got status %d", status)
+	}
+	if _, err := newClient().Get(srv.URL); err == nil {
+		t.Fatalf("Expected client without certificate to be rejected")
+	}
+
+	// the client auth type is read again for every handshake
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(`
+tls_server_config:
+  cert_file: %[1]s/server.crt
+  key_file: %[1]s/server.key
+`, dir)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if status := get(t, newClient(), srv.URL, "", ""); status != http.StatusOK {
+		t.Fatalf("Expected client without certificate to be accepted after reload, got status %d", status)
+	}
+}
+
+// users returns a web config with the given user and the bcrypt hash of its password
+func users(t *testing.T, user, password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("basic_auth_users:\n  %s: %s\n", user, hash)
+}
+
+func get(t *testing.T, client *http.Client, url, user, password string) int {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user != "" {
+		req.SetBasicAuth(user, password)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to send request, got err:\n%v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newCertificate returns a certificate for the given name, signed by the given parent. Without
+// parent, a self-signed ca is returned.
+func newCertificate(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// writePEM writes the certificate to the given path and its key next to it with the extension .key
+func writePEM(t *testing.T, path string, cert *x509.Certificate, key *ecdsa.PrivateKey) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".key"
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
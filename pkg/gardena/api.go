@@ -1,14 +1,15 @@
 package gardena
 
 import (
-	"encoding/json"
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -16,21 +17,38 @@ const baseURL = "https://api.smart.gardena.dev/v1"
 const husqvarnaTokenURL = "https://api.authentication.husqvarnagroup.dev/v1/oauth2/token"
 const ApiHealthURL = "/health"
 const LocationsURL = "/locations"
+const WebsocketURL = "/websocket"
+
+const jsonAPIContentType = "application/vnd.api+json"
 
 const clientIDFile = "client-id"
 const clientSecretFile = "client-secret"
 
 type API struct {
-	baseURL        string
-	authUrl        string
-	httpClient     *http.Client
-	secretFilePath string
+	baseURL     string
+	authUrl     string
+	httpClient  *http.Client
+	credentials CredentialProvider
+	// recording is the file requests are recorded to, nil if not recording
+	recording io.Closer
 
+	// refreshMu serializes token refreshes, tokenMu guards the credentials and the token itself, so
+	// requests can keep using the current token while a new one is requested. The credentials are only
+	// changed holding both.
+	refreshMu    sync.Mutex
+	tokenMu      sync.RWMutex
 	clientID     string
 	clientSecret string
 	accessToken  string
 	userID       string
 	tokenExpAt   time.Time
+	// tokenTTL is the lifetime of the current access token, zero if unknown
+	tokenTTL time.Duration
+
+	limiter *limiter
+
+	reloadMu     sync.Mutex
+	reloadStatus CredentialReloadStatus
 }
 
 type authResponse struct {
@@ -44,6 +62,9 @@ type authResponse struct {
 
 type APIBuilder struct {
 	api *API
+
+	recordPath string
+	replayPath string
 }
 
 // NewAPI returns an APIBuilder with the default base/authentication url
@@ -54,6 +75,7 @@ func NewAPI() *APIBuilder {
 		baseURL:    baseURL,
 		authUrl:    husqvarnaTokenURL,
 		httpClient: &http.Client{Timeout: 10 * time.Second},
+		limiter:    newLimiter(),
 	}}
 }
 
@@ -69,72 +91,125 @@ func (b *APIBuilder) WithAuthURL(u string) *APIBuilder {
 	return b
 }
 
-// WithSecretFilePath sets the path the required secret files
+// WithRequestBudget limits the requests of the API to the given number of requests per period, e.g.
+// 700 requests per week. Requests exceeding the budget fail with ErrBudgetExhausted without being sent.
+// Multiple budgets can be configured, e.g. a weekly and a daily one.
+func (b *APIBuilder) WithRequestBudget(limit int, period time.Duration) *APIBuilder {
+	b.api.limiter.addBudget(limit, period)
+	return b
+}
+
+// WithRecording appends all requests of the API and their responses to the recording file at the given
+// path. Credentials and serials are redacted, so recordings can be shared to reproduce issues.
+func (b *APIBuilder) WithRecording(path string) *APIBuilder {
+	b.recordPath = path
+	return b
+}
+
+// WithReplay answers all requests of the API with the responses of the recording file at the given
+// path instead of sending them. Replaying doesn't require secret files.
+func (b *APIBuilder) WithReplay(path string) *APIBuilder {
+	b.replayPath = path
+	return b
+}
+
+// WithSecretFilePath sets the path the required secret files, it's a shorthand for
+// WithCredentialProvider(SecretFilesProvider{Dir: p})
 func (b *APIBuilder) WithSecretFilePath(p string) *APIBuilder {
-	b.api.secretFilePath = p
+	return b.WithCredentialProvider(SecretFilesProvider{Dir: p})
+}
+
+// WithCredentialProvider sets the provider of the client-id and client-secret, e.g. EnvProvider
+func (b *APIBuilder) WithCredentialProvider(p CredentialProvider) *APIBuilder {
+	b.api.credentials = p
 	return b
 }
 
 // Initialize initializes the API from the Builder.
-// The client-id and client-secret are read from the configured secret files. Also,
+// The client-id and client-secret are read from the configured credential provider. Also,
 // the api authenticates, acquiring an access token.
 func (b *APIBuilder) Initialize() (*API, error) {
-	api := b.api
-	if api.secretFilePath == "" {
-		return nil, fmt.Errorf("secretpath can not be empty")
-	}
+	return b.InitializeWithContext(context.Background())
+}
 
-	if !strings.HasSuffix(api.secretFilePath, "/") {
-		api.secretFilePath = api.secretFilePath + "/"
-	}
-	clientID, err := readFromSecretFile(api.secretFilePath + clientIDFile)
-	if err != nil {
-		return nil, fmt.Errorf("unable to read client-id from secret file, got err:\n %w", err)
+// InitializeWithContext initializes the API like Initialize, the given context is used
+// to cancel the authentication.
+func (b *APIBuilder) InitializeWithContext(ctx context.Context) (*API, error) {
+	api := b.api
+	if err := b.setupTransport(); err != nil {
+		return nil, err
 	}
-	api.clientID = clientID
-	clientSecret, err := readFromSecretFile(api.secretFilePath + clientSecretFile)
-	if err != nil {
-		return nil, fmt.Errorf("unable to read client-secret from secret file, got err:\n %w", err)
+	if b.replayPath != "" {
+		api.clientID, api.clientSecret = redacted, redacted
+	} else if err := api.readCredentials(ctx); err != nil {
+		api.Close()
+		return nil, err
 	}
-	api.clientSecret = clientSecret
 
-	if err := api.authenticate(); err != nil {
+	if err := api.authenticateWithContext(ctx); err != nil {
+		api.Close()
 		return nil, fmt.Errorf("unable to authenticate, got err:\n %w", err)
 	}
 	log.Println("Successfully initialized gardena smart system api!")
 	return api, nil
 }
 
-// authenticate requests an access token from the configured authentication endpoint and stores it in the API
-func (api *API) authenticate() error {
-	if api.clientID == "" || api.clientSecret == "" {
-		return fmt.Errorf("api not initialized, client-id or client-secret was empty")
+// setupTransport sets up the transport of the http client for recording or replaying
+func (b *APIBuilder) setupTransport() error {
+	if b.recordPath != "" && b.replayPath != "" {
+		return fmt.Errorf("recording and replaying can not be combined")
 	}
-	if api.accessToken == "" || !api.tokenExpAt.IsZero() && time.Now().After(api.tokenExpAt.Add(time.Duration(-3600))) {
-		res, err := api.httpClient.PostForm(api.authUrl, url.Values{
-			"grant_type":    {"client_credentials"},
-			"client_id":     {api.clientID},
-			"client_secret": {api.clientSecret},
-		})
+	next := b.api.httpClient.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if b.recordPath != "" {
+		f, err := os.OpenFile(b.recordPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
 		if err != nil {
-			return fmt.Errorf("unable to request access token, got err %w", err)
+			return fmt.Errorf("unable to open recording, got err:\n %w", err)
 		}
-		defer res.Body.Close()
-		body, err := io.ReadAll(res.Body)
+		b.api.httpClient.Transport = newRecordingTransport(next, f)
+		b.api.recording = f
+		log.Printf("Recording requests to %s", b.recordPath)
+	}
+	if b.replayPath != "" {
+		interactions, err := ReadRecording(b.replayPath)
 		if err != nil {
-			return fmt.Errorf("unable to read authentication response, got error: %w", err)
+			return err
 		}
-		var auth authResponse
-		if err := json.Unmarshal(body, &auth); err != nil {
-			return fmt.Errorf("unable to parse authentication response to json, err: %w", err)
+		t, err := newReplayTransport(interactions)
+		if err != nil {
+			return err
 		}
-		api.userID = auth.UserID
-		api.accessToken = auth.TokenType + " " + auth.AccessToken
-		api.tokenExpAt = time.Now().Add(time.Second * time.Duration(auth.ExpiresIn))
+		b.api.httpClient.Transport = t
+		log.Printf("Replaying %d recorded requests of %s", len(interactions), b.replayPath)
 	}
 	return nil
 }
 
+// Close closes the recording file of the API, see WithRecording. Requests sent afterwards fail
+// to be recorded.
+func (api *API) Close() error {
+	if api.recording == nil {
+		return nil
+	}
+	return api.recording.Close()
+}
+
+// readCredentials reads the client-id and client-secret from the configured credential provider
+func (api *API) readCredentials(ctx context.Context) error {
+	if api.credentials == nil {
+		return fmt.Errorf("no credential provider configured")
+	}
+	c, err := api.credentials.Credentials(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to read credentials from %s, got err:\n %w", api.credentials, err)
+	}
+	log.Printf("Using credentials from %s", api.credentials)
+	api.clientID, api.clientSecret = c.ClientID, c.ClientSecret
+	return nil
+}
+
 // GetAPIHealthURL returns the health url for the API with the configured base url
 func (api *API) GetAPIHealthURL() string {
 	return api.baseURL + ApiHealthURL
@@ -147,61 +222,118 @@ func (api *API) GetBaseURL() string {
 
 // query sets up an HTTP GET request against the configured base url + the given path, using the
 // configured client id and access token. The response is returned as http.Response
-func (api *API) query(path string) (*http.Response, error) {
-	req, err := http.NewRequest(http.MethodGet, api.baseURL+path, nil)
-	if err != nil {
-		return nil, fmt.Errorf("unable to setup request for endpoint %s%s, got err:\n %w", baseURL, path, err)
-	}
-	req.Header.Set("X-Api-Key", api.clientID)
-	req.Header.Set("Authorization", api.accessToken)
-	res, err := api.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("unable to query endpoint %s%s, got err:\n %w", baseURL, path, err)
+func (api *API) query(ctx context.Context, path string) (*http.Response, error) {
+	return api.request(ctx, http.MethodGet, path, nil)
+}
+
+// request sets up an HTTP request with the given method against the configured base url + the given
+// path, using the configured client id and access token. A non nil body is sent as JSON:API document.
+// The request is canceled with the given context. The response is returned as http.Response
+//
+// Every request counts against the configured request budgets. If the api answers with 429 Too Many
+// Requests, the request is retried after the time given by the Retry-After header or an exponential
+// backoff, all further requests are held back until then. If the api answers with 401 Unauthorized,
+// the request is retried once with a new access token.
+func (api *API) request(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	throttled := 0
+	reauthenticated := false
+	for {
+		if err := api.limiter.acquire(ctx); err != nil {
+			return nil, fmt.Errorf("unable to query endpoint %s%s, got err:\n %w", api.baseURL, path, err)
+		}
+		clientID, token, err := api.token(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to authenticate request for endpoint %s%s, got err:\n %w", api.baseURL, path, err)
+		}
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, api.baseURL+path, reader)
+		if err != nil {
+			return nil, fmt.Errorf("unable to setup request for endpoint %s%s, got err:\n %w", api.baseURL, path, err)
+		}
+		req.Header.Set("X-Api-Key", clientID)
+		req.Header.Set("Authorization", token)
+		if body != nil {
+			req.Header.Set("Content-Type", jsonAPIContentType)
+		}
+		res, err := api.httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("unable to query endpoint %s%s, got err:\n %w", api.baseURL, path, err)
+		}
+
+		switch {
+		case res.StatusCode == http.StatusUnauthorized && !reauthenticated && api.authUrl != "":
+			// the token might have been revoked before it expired, retry once with a new one
+			res.Body.Close()
+			reauthenticated = true
+			if err := api.refreshToken(ctx, token); err != nil {
+				return nil, fmt.Errorf("endpoint %s%s rejected the access token, got err:\n %w", api.baseURL, path, err)
+			}
+		case res.StatusCode == http.StatusTooManyRequests:
+			api.limiter.throttle(res.Header.Get("Retry-After"))
+			throttled++
+			if throttled > maxThrottleRetries {
+				err := newStatusError(res)
+				res.Body.Close()
+				return nil, fmt.Errorf("giving up after %d attempts, got err:\n %w", throttled, err)
+			}
+			res.Body.Close()
+		default:
+			api.limiter.succeeded()
+			return res, nil
+		}
 	}
-	return res, nil
 }
 
 // GetLocations queries the locations of the LocationsURL and returns the result as json
 func (api *API) GetLocations() (*Locations, error) {
-	res, err := api.query(LocationsURL)
+	return api.GetLocationsWithContext(context.Background())
+}
+
+// GetLocationsWithContext queries the locations like GetLocations, the given context is used
+// to cancel the request
+func (api *API) GetLocationsWithContext(ctx context.Context) (*Locations, error) {
+	res, err := api.query(ctx, LocationsURL)
 	if err != nil {
 		return nil, fmt.Errorf("querying for locations failed, got err:\n %w", err)
 	}
+	defer res.Body.Close()
 
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("unable to read response, got status code %d, response: %v", res.StatusCode, res)
-	}
-
-	responseBody, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("unable to read response, got error:\n%w", err)
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("querying for locations failed, got err:\n %w", newStatusError(res))
 	}
 
 	locations := Locations{}
-	if err := json.Unmarshal(responseBody, &locations); err != nil {
+	if err := decodeResponse(res, &locations); err != nil {
 		return nil, fmt.Errorf("unmarshal of locations response failed, got err:\n%w", err)
 	}
 	return &locations, nil
 }
 
+// GetInitialStateFor queries the current state of the given location, including all of its devices
+// and their services. Despite its name, it can be called repeatedly to poll for the latest state.
 func (api *API) GetInitialStateFor(location Location) (*State, error) {
-	res, err := api.query(LocationsURL + "/" + location.Id)
+	return api.GetInitialStateForWithContext(context.Background(), location)
+}
+
+// GetInitialStateForWithContext queries the state of the given location like GetInitialStateFor,
+// the given context is used to cancel the request
+func (api *API) GetInitialStateForWithContext(ctx context.Context, location Location) (*State, error) {
+	res, err := api.query(ctx, LocationsURL+"/"+location.Id)
 	if err != nil {
 		return nil, fmt.Errorf("unable to query location %s, got err:\n%w", location.Id, err)
 	}
+	defer res.Body.Close()
 
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("unable to read response, got status code %d, response: %v", res.StatusCode, res)
-	}
-
-	responseBody, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("unmarshal of locations response failed, got err:\n%w", err)
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to query location %s, got err:\n%w", location.Id, newStatusError(res))
 	}
 
 	state := State{}
-	if err := json.Unmarshal(responseBody, &state); err != nil {
-		return nil, fmt.Errorf("unable to unmarshal json state, got error: %v", res)
+	if err := decodeResponse(res, &state); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal json state, got err:\n%w", err)
 	}
 	return &state, nil
 }
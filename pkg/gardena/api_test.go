@@ -1,6 +1,8 @@
 package gardena
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -187,6 +189,22 @@ func TestAPI_authenticateRefreshExpiredToken(t *testing.T) {
 	}
 }
 
+func TestGetLocationsWithContextCanceled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	api := API{baseURL: server.URL, httpClient: &http.Client{}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := api.GetLocationsWithContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected request to be canceled by the context, got err %v", err)
+	}
+}
+
 // setupSecretFilesWithTmpDir creates a tmp dir and writes the provided information into the expected
 // files. Cleanup with defer os.RemoveAll(secretFilePath)
 func setupSecretFilesWithTmpDir(clientID, clientSecret string) string {
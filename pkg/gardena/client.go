@@ -0,0 +1,22 @@
+package gardena
+
+import "context"
+
+// Client is the interface of the gardena api used to monitor and control devices. API implements
+// Client, alternative implementations can e.g. replay recorded responses or serve fakes in tests.
+type Client interface {
+	// GetLocationsWithContext returns all locations of the account
+	GetLocationsWithContext(ctx context.Context) (*Locations, error)
+	// GetInitialStateForWithContext returns the current state of the given location
+	GetInitialStateForWithContext(ctx context.Context, location Location) (*State, error)
+	// GetWebsocketURLWithContext returns a url of the realtime websocket of the given location
+	GetWebsocketURLWithContext(ctx context.Context, location Location) (string, error)
+	// SendCommandWithContext sends the given command to the service with the given id
+	SendCommandWithContext(ctx context.Context, serviceId string, cmd Command) (*CommandResult, error)
+	// GetAPIHealthURL returns the url of the health endpoint of the api
+	GetAPIHealthURL() string
+	// GetBaseURL returns the base url of the api
+	GetBaseURL() string
+}
+
+var _ Client = (*API)(nil)
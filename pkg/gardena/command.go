@@ -0,0 +1,229 @@
+package gardena
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+const CommandURL = "/command"
+
+const (
+	typeMowerControl       = "MOWER_CONTROL"
+	typeValveControl       = "VALVE_CONTROL"
+	typePowerSocketControl = "POWER_SOCKET_CONTROL"
+)
+
+// Command is a control command for a single service of a device, e.g. a MOWER.
+// Commands are validated before they are sent.
+type Command interface {
+	// Validate returns an error if the command can't be sent as it is
+	Validate() error
+	// controlType returns the JSON:API type of the command, e.g. MOWER_CONTROL
+	controlType() string
+	// attributes returns the JSON:API attributes of the command
+	attributes() map[string]any
+}
+
+// CommandResult describes a command accepted by the api. Commands are executed asynchronously,
+// the resulting change of the device is reported through its state.
+type CommandResult struct {
+	RequestId  string
+	StatusCode int
+}
+
+type MowerCommand string
+
+const (
+	// MowerStartSecondsToOverride starts the mower, overriding the schedule for the given seconds
+	MowerStartSecondsToOverride MowerCommand = "START_SECONDS_TO_OVERRIDE"
+	// MowerStartDontOverride resumes the schedule of the mower
+	MowerStartDontOverride MowerCommand = "START_DONT_OVERRIDE"
+	// MowerParkUntilNextTask parks the mower until the next scheduled task
+	MowerParkUntilNextTask MowerCommand = "PARK_UNTIL_NEXT_TASK"
+	// MowerParkUntilFurtherNotice parks the mower, ignoring the schedule
+	MowerParkUntilFurtherNotice MowerCommand = "PARK_UNTIL_FURTHER_NOTICE"
+)
+
+// MowerControl is a command for a MOWER service. Seconds is required for
+// MowerStartSecondsToOverride and must be a positive multiple of 60.
+type MowerControl struct {
+	Command MowerCommand
+	Seconds int
+}
+
+func (c MowerControl) Validate() error {
+	switch c.Command {
+	case MowerStartSecondsToOverride:
+		return validateSeconds(string(c.Command), c.Seconds)
+	case MowerStartDontOverride, MowerParkUntilNextTask, MowerParkUntilFurtherNotice:
+		return validateNoSeconds(string(c.Command), c.Seconds)
+	default:
+		return fmt.Errorf("unsupported mower command '%s'", c.Command)
+	}
+}
+
+func (c MowerControl) controlType() string {
+	return typeMowerControl
+}
+
+func (c MowerControl) attributes() map[string]any {
+	return commandAttributes(string(c.Command), c.Seconds)
+}
+
+type ValveCommand string
+
+const (
+	// ValveStartSecondsToOverride opens the valve for the given seconds, overriding the schedule
+	ValveStartSecondsToOverride ValveCommand = "START_SECONDS_TO_OVERRIDE"
+	// ValveStopUntilNextTask closes the valve until the next scheduled task
+	ValveStopUntilNextTask ValveCommand = "STOP_UNTIL_NEXT_TASK"
+	// ValvePause skips the schedule of the valve until UNPAUSE
+	ValvePause ValveCommand = "PAUSE"
+	// ValveUnpause resumes the schedule of the valve
+	ValveUnpause ValveCommand = "UNPAUSE"
+)
+
+// ValveControl is a command for a VALVE service. Seconds is required for
+// ValveStartSecondsToOverride and must be a positive multiple of 60.
+type ValveControl struct {
+	Command ValveCommand
+	Seconds int
+}
+
+func (c ValveControl) Validate() error {
+	switch c.Command {
+	case ValveStartSecondsToOverride:
+		return validateSeconds(string(c.Command), c.Seconds)
+	case ValveStopUntilNextTask, ValvePause, ValveUnpause:
+		return validateNoSeconds(string(c.Command), c.Seconds)
+	default:
+		return fmt.Errorf("unsupported valve command '%s'", c.Command)
+	}
+}
+
+func (c ValveControl) controlType() string {
+	return typeValveControl
+}
+
+func (c ValveControl) attributes() map[string]any {
+	return commandAttributes(string(c.Command), c.Seconds)
+}
+
+type PowerSocketCommand string
+
+const (
+	// PowerSocketStartSecondsToOverride switches the socket on for the given seconds, overriding the schedule
+	PowerSocketStartSecondsToOverride PowerSocketCommand = "START_SECONDS_TO_OVERRIDE"
+	// PowerSocketStartOverride switches the socket on, ignoring the schedule
+	PowerSocketStartOverride PowerSocketCommand = "START_OVERRIDE"
+	// PowerSocketStopUntilNextTask switches the socket off until the next scheduled task
+	PowerSocketStopUntilNextTask PowerSocketCommand = "STOP_UNTIL_NEXT_TASK"
+	// PowerSocketPause skips the schedule of the socket until UNPAUSE
+	PowerSocketPause PowerSocketCommand = "PAUSE"
+	// PowerSocketUnpause resumes the schedule of the socket
+	PowerSocketUnpause PowerSocketCommand = "UNPAUSE"
+)
+
+// PowerSocketControl is a command for a POWER_SOCKET service. Seconds is required for
+// PowerSocketStartSecondsToOverride and must be a positive multiple of 60.
+type PowerSocketControl struct {
+	Command PowerSocketCommand
+	Seconds int
+}
+
+func (c PowerSocketControl) Validate() error {
+	switch c.Command {
+	case PowerSocketStartSecondsToOverride:
+		return validateSeconds(string(c.Command), c.Seconds)
+	case PowerSocketStartOverride, PowerSocketStopUntilNextTask, PowerSocketPause, PowerSocketUnpause:
+		return validateNoSeconds(string(c.Command), c.Seconds)
+	default:
+		return fmt.Errorf("unsupported power socket command '%s'", c.Command)
+	}
+}
+
+func (c PowerSocketControl) controlType() string {
+	return typePowerSocketControl
+}
+
+func (c PowerSocketControl) attributes() map[string]any {
+	return commandAttributes(string(c.Command), c.Seconds)
+}
+
+type commandRequest struct {
+	Data struct {
+		Id         string         `json:"id"`
+		Type       string         `json:"type"`
+		Attributes map[string]any `json:"attributes"`
+	} `json:"data"`
+}
+
+// SendCommand validates the given command and sends it to the service with the given id.
+// The api accepts commands asynchronously, a rejected command is returned as *StatusError
+// containing the JSON:API errors of the response.
+func (api *API) SendCommand(serviceId string, cmd Command) (*CommandResult, error) {
+	return api.SendCommandWithContext(context.Background(), serviceId, cmd)
+}
+
+// SendCommandWithContext sends the given command like SendCommand, the given context is used
+// to cancel the request
+func (api *API) SendCommandWithContext(ctx context.Context, serviceId string, cmd Command) (*CommandResult, error) {
+	if err := cmd.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid command for service %s, got err:\n%w", serviceId, err)
+	}
+	var r commandRequest
+	r.Data.Id = newRequestId()
+	r.Data.Type = cmd.controlType()
+	r.Data.Attributes = cmd.attributes()
+	body, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal command, got err:\n%w", err)
+	}
+
+	res, err := api.request(ctx, http.MethodPut, CommandURL+"/"+url.PathEscape(serviceId), body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to send command to service %s, got err:\n%w", serviceId, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusAccepted && res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("command %s for service %s was rejected, got err:\n%w", r.Data.Type, serviceId, newStatusError(res))
+	}
+	return &CommandResult{RequestId: r.Data.Id, StatusCode: res.StatusCode}, nil
+}
+
+// commandAttributes returns the attributes of a command, seconds are only added if set
+func commandAttributes(command string, seconds int) map[string]any {
+	attrs := map[string]any{"command": command}
+	if seconds != 0 {
+		attrs["seconds"] = seconds
+	}
+	return attrs
+}
+
+func validateSeconds(command string, seconds int) error {
+	if seconds <= 0 || seconds%60 != 0 {
+		return fmt.Errorf("command %s requires seconds to be a positive multiple of 60, got %d", command, seconds)
+	}
+	return nil
+}
+
+func validateNoSeconds(command string, seconds int) error {
+	if seconds != 0 {
+		return fmt.Errorf("command %s doesn't support seconds, got %d", command, seconds)
+	}
+	return nil
+}
+
+// newRequestId creates a random id for a command request
+func newRequestId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "request"
+	}
+	return hex.EncodeToString(b)
+}
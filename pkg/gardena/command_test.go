@@ -0,0 +1,124 @@
+package gardena
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSendMowerCommand(t *testing.T) {
+	var received commandRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != CommandURL+"/dev-2-id" {
+			t.Errorf("Expected PUT %s/dev-2-id, got %s %s", CommandURL, r.Method, r.URL.Path)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != jsonAPIContentType {
+			t.Errorf("Expected content type %s, got %s", jsonAPIContentType, ct)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Unable to decode command, got err:\n%v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	api := API{baseURL: server.URL, httpClient: &http.Client{}}
+	res, err := api.SendCommand("dev-2-id", MowerControl{Command: MowerStartSecondsToOverride, Seconds: 3600})
+	if err != nil {
+		t.Fatalf("Unable to send command, got err:\n%v", err)
+	}
+	if res.StatusCode != http.StatusAccepted || res.RequestId != received.Data.Id {
+		t.Fatalf("Unexpected result %+v for request with id %s", res, received.Data.Id)
+	}
+	if received.Data.Type != typeMowerControl {
+		t.Fatalf("Expected type %s, got %s", typeMowerControl, received.Data.Type)
+	}
+	if received.Data.Attributes["command"] != string(MowerStartSecondsToOverride) || received.Data.Attributes["seconds"] != float64(3600) {
+		t.Fatalf("Unexpected attributes %v", received.Data.Attributes)
+	}
+}
+
+func TestSendCommandRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"errors": [{"id": "1", "status": "400", "code": "INVALID_COMMAND", "title": "Invalid command", "detail": "mower is in error"}]}`))
+	}))
+	defer server.Close()
+
+	api := API{baseURL: server.URL, httpClient: &http.Client{}}
+	_, err := api.SendCommand("dev-2-id", MowerControl{Command: MowerParkUntilNextTask})
+	if err == nil {
+		t.Fatalf("Expected rejected command to return an error")
+	}
+	if !strings.Contains(err.Error(), "INVALID_COMMAND: Invalid command: mower is in error") {
+		t.Fatalf("Expected error to contain the JSON:API error, got %v", err)
+	}
+}
+
+func TestMowerControlValidate(t *testing.T) {
+	invalid := []MowerControl{
+		{Command: "FLY"},
+		{Command: MowerStartSecondsToOverride},
+		{Command: MowerStartSecondsToOverride, Seconds: 90},
+		{Command: MowerParkUntilFurtherNotice, Seconds: 60},
+	}
+	for _, c := range invalid {
+		if err := c.Validate(); err == nil {
+			t.Fatalf("Expected %+v to be invalid", c)
+		}
+	}
+
+	// invalid commands are not sent at all
+	api := API{baseURL: "http://127.0.0.1:0", httpClient: &http.Client{}}
+	if _, err := api.SendCommand("dev-2-id", invalid[0]); err == nil || !strings.Contains(err.Error(), "invalid command") {
+		t.Fatalf("Expected validation error, got %v", err)
+	}
+}
+
+func TestSendValveAndPowerSocketCommands(t *testing.T) {
+	var received []commandRequest
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var c commandRequest
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			t.Errorf("Unable to decode command, got err:\n%v", err)
+		}
+		received = append(received, c)
+		paths = append(paths, r.URL.Path)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	api := API{baseURL: server.URL, httpClient: &http.Client{}}
+	if _, err := api.SendCommand("dev-3-id:1", ValveControl{Command: ValveStartSecondsToOverride, Seconds: 600}); err != nil {
+		t.Fatalf("Unable to send valve command, got err:\n%v", err)
+	}
+	if _, err := api.SendCommand("dev-4-id", PowerSocketControl{Command: PowerSocketStartOverride}); err != nil {
+		t.Fatalf("Unable to send power socket command, got err:\n%v", err)
+	}
+
+	if paths[0] != CommandURL+"/dev-3-id:1" || received[0].Data.Type != typeValveControl || received[0].Data.Attributes["seconds"] != float64(600) {
+		t.Fatalf("Unexpected valve command %+v to %s", received[0], paths[0])
+	}
+	if paths[1] != CommandURL+"/dev-4-id" || received[1].Data.Type != typePowerSocketControl || received[1].Data.Attributes["command"] != "START_OVERRIDE" {
+		t.Fatalf("Unexpected power socket command %+v to %s", received[1], paths[1])
+	}
+	if _, ok := received[1].Data.Attributes["seconds"]; ok {
+		t.Fatalf("Expected no seconds for %s, got %v", PowerSocketStartOverride, received[1].Data.Attributes)
+	}
+
+	invalid := []Command{
+		ValveControl{Command: ValveStartSecondsToOverride},
+		ValveControl{Command: ValvePause, Seconds: 60},
+		ValveControl{Command: "START_OVERRIDE"},
+		PowerSocketControl{Command: PowerSocketStartSecondsToOverride, Seconds: -60},
+		PowerSocketControl{Command: "PARK_UNTIL_NEXT_TASK"},
+	}
+	for _, c := range invalid {
+		if err := c.Validate(); err == nil {
+			t.Fatalf("Expected %+v to be invalid", c)
+		}
+	}
+}
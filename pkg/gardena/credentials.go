@@ -0,0 +1,173 @@
+package gardena
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultClientIDEnv is the default environment variable of the client-id
+	DefaultClientIDEnv = "GARDENA_CLIENT_ID"
+	// DefaultClientSecretEnv is the default environment variable of the client-secret
+	DefaultClientSecretEnv = "GARDENA_CLIENT_SECRET"
+)
+
+// credentialCommandTimeout is the max time a credentials command may take
+const credentialCommandTimeout = 30 * time.Second
+
+// Credentials are the client credentials of an application of the husqvarna developer portal
+type Credentials struct {
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+}
+
+// CredentialProvider provides the client credentials the API authenticates with
+type CredentialProvider interface {
+	// Credentials returns the current credentials, the given context is used to cancel reading them
+	Credentials(ctx context.Context) (Credentials, error)
+	// String describes the source of the credentials, without revealing them
+	String() string
+}
+
+// SecretFilesProvider reads the credentials from the files client-id and client-secret in a directory
+type SecretFilesProvider struct {
+	Dir string
+}
+
+func (p SecretFilesProvider) Credentials(context.Context) (Credentials, error) {
+	if p.Dir == "" {
+		return Credentials{}, fmt.Errorf("secretpath can not be empty")
+	}
+	dir := p.Dir
+	if !strings.HasSuffix(dir, "/") {
+		dir = dir + "/"
+	}
+	clientID, err := readFromSecretFile(dir + clientIDFile)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("unable to read client-id from secret file, got err:\n %w", err)
+	}
+	clientSecret, err := readFromSecretFile(dir + clientSecretFile)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("unable to read client-secret from secret file, got err:\n %w", err)
+	}
+	return Credentials{ClientID: clientID, ClientSecret: clientSecret}, nil
+}
+
+func (p SecretFilesProvider) String() string {
+	return "secret files in " + p.Dir
+}
+
+// EnvProvider reads the credentials from environment variables. Empty variable names default to
+// DefaultClientIDEnv and DefaultClientSecretEnv.
+type EnvProvider struct {
+	ClientIDVar     string
+	ClientSecretVar string
+}
+
+func (p EnvProvider) Credentials(context.Context) (Credentials, error) {
+	idVar, secretVar := p.vars()
+	c := Credentials{ClientID: os.Getenv(idVar), ClientSecret: os.Getenv(secretVar)}
+	if c.ClientID == "" || c.ClientSecret == "" {
+		return Credentials{}, fmt.Errorf("environment variables %s and %s have to be set", idVar, secretVar)
+	}
+	return c, nil
+}
+
+func (p EnvProvider) String() string {
+	idVar, secretVar := p.vars()
+	return "environment variables " + idVar + " and " + secretVar
+}
+
+func (p EnvProvider) vars() (string, string) {
+	idVar, secretVar := p.ClientIDVar, p.ClientSecretVar
+	if idVar == "" {
+		idVar = DefaultClientIDEnv
+	}
+	if secretVar == "" {
+		secretVar = DefaultClientSecretEnv
+	}
+	return idVar, secretVar
+}
+
+// CredentialsFileProvider reads the credentials from a single JSON or YAML file with the keys
+// client_id and client_secret
+type CredentialsFileProvider struct {
+	Path string
+}
+
+func (p CredentialsFileProvider) Credentials(context.Context) (Credentials, error) {
+	b, err := os.ReadFile(p.Path)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("unable to read credentials file, got err:\n %w", err)
+	}
+	c, err := parseCredentials(b)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("invalid credentials file %s, got err:\n %w", p.Path, err)
+	}
+	return c, nil
+}
+
+func (p CredentialsFileProvider) String() string {
+	return "credentials file " + p.Path
+}
+
+// CommandProvider runs a command printing the credentials as JSON or YAML with the keys client_id
+// and client_secret to stdout, e.g. a password manager cli
+type CommandProvider struct {
+	// Command is the name of the command followed by its arguments
+	Command []string
+	// Name describes the command in logs, e.g. the name of the command run by a shell. Defaults to
+	// the name of the command.
+	Name string
+}
+
+func (p CommandProvider) Credentials(ctx context.Context) (Credentials, error) {
+	if len(p.Command) == 0 {
+		return Credentials{}, fmt.Errorf("credentials command can not be empty")
+	}
+	ctx, cancel := context.WithTimeout(ctx, credentialCommandTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Command[0], p.Command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return Credentials{}, fmt.Errorf("credentials command failed, got err:\n %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	c, err := parseCredentials(stdout.Bytes())
+	if err != nil {
+		return Credentials{}, fmt.Errorf("invalid output of credentials command, got err:\n %w", err)
+	}
+	return c, nil
+}
+
+func (p CommandProvider) String() string {
+	switch {
+	case p.Name != "":
+		return "command " + p.Name
+	case len(p.Command) == 0:
+		return "empty command"
+	default:
+		return "command " + filepath.Base(p.Command[0])
+	}
+}
+
+// parseCredentials parses JSON or YAML credentials, both the client_id and the client_secret are required.
+// Errors don't contain the input, since it's likely a secret.
+func parseCredentials(b []byte) (Credentials, error) {
+	var c Credentials
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return Credentials{}, fmt.Errorf("expected JSON or YAML with the keys client_id and client_secret")
+	}
+	if c.ClientID == "" || c.ClientSecret == "" {
+		return Credentials{}, fmt.Errorf("client_id and client_secret are required")
+	}
+	return c, nil
+}
@@ -0,0 +1,92 @@
+package gardena
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCredentialProviders(t *testing.T) {
+	expected := Credentials{ClientID: "<some-client-id>", ClientSecret: "<some-client-secret>"}
+	secretFilePath := setupSecretFilesWithTmpDir(expected.ClientID, expected.ClientSecret)
+	defer os.RemoveAll(secretFilePath)
+
+	dir := t.TempDir()
+	jsonFile := filepath.Join(dir, "credentials.json")
+	if err := os.WriteFile(jsonFile, []byte(`{"client_id": "<some-client-id>", "client_secret": "<some-client-secret>"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	yamlFile := filepath.Join(dir, "credentials.yaml")
+	if err := os.WriteFile(yamlFile, []byte("client_id: <some-client-id>\nclient_secret: <some-client-secret>\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_CLIENT_ID", expected.ClientID)
+	t.Setenv("TEST_CLIENT_SECRET", expected.ClientSecret)
+
+	providers := []CredentialProvider{
+		SecretFilesProvider{Dir: secretFilePath},
+		EnvProvider{ClientIDVar: "TEST_CLIENT_ID", ClientSecretVar: "TEST_CLIENT_SECRET"},
+		CredentialsFileProvider{Path: jsonFile},
+		CredentialsFileProvider{Path: yamlFile},
+		CommandProvider{Command: []string{"cat", yamlFile}},
+	}
+	for _, p := range providers {
+		t.Run(p.String(), func(t *testing.T) {
+			c, err := p.Credentials(context.Background())
+			if err != nil {
+				t.Fatalf("Unable to read credentials, got err:\n%v", err)
+			}
+			if c != expected {
+				t.Fatalf("Expected credentials %+v, got %+v", expected, c)
+			}
+		})
+	}
+}
+
+func TestCredentialProvidersFail(t *testing.T) {
+	dir := t.TempDir()
+	incomplete := filepath.Join(dir, "credentials.yaml")
+	if err := os.WriteFile(incomplete, []byte("client_id: <some-client-id>\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(DefaultClientIDEnv, "<some-client-id>")
+	t.Setenv(DefaultClientSecretEnv, "")
+
+	providers := []CredentialProvider{
+		SecretFilesProvider{},
+		SecretFilesProvider{Dir: dir},
+		EnvProvider{},
+		CredentialsFileProvider{Path: incomplete},
+		CredentialsFileProvider{Path: filepath.Join(dir, "missing.yaml")},
+		CommandProvider{},
+		CommandProvider{Command: []string{"sh", "-c", "echo 'no access' >&2; exit 1"}},
+		CommandProvider{Command: []string{"cat", incomplete}},
+		// a bare secret is no valid YAML document of credentials
+		CommandProvider{Command: []string{"echo", "<some-client-id>"}},
+	}
+	for _, p := range providers {
+		t.Run(p.String(), func(t *testing.T) {
+			c, err := p.Credentials(context.Background())
+			if err == nil {
+				t.Fatalf("Expected reading credentials to fail, got %+v", c)
+			}
+			if strings.Contains(err.Error(), "<some") {
+				t.Fatalf("Expected error not to reveal the credentials, got err:\n%v", err)
+			}
+		})
+	}
+}
+
+func TestCommandProviderString(t *testing.T) {
+	for p, expected := range map[*CommandProvider]string{
+		{Command: []string{"/usr/bin/pass", "show", "garden"}}:                                  "command pass",
+		{Command: []string{"/bin/sh", "-c", "vault kv get -format=json garden"}, Name: "vault"}: "command vault",
+		{}: "empty command",
+	} {
+		if s := p.String(); s != expected {
+			t.Fatalf("Expected %s, got %s", expected, s)
+		}
+	}
+}
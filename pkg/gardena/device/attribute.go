@@ -0,0 +1,118 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// ErrAttrNotSet is returned for attributes a device supports, but didn't report
+var ErrAttrNotSet = errors.New("attribute not set")
+
+// optional holds the value of an attribute that might not be reported by a device
+type optional[T any] struct {
+	value T
+	set   bool
+}
+
+// get returns the value of the attribute with the given key or ErrAttrNotSet if it wasn't reported
+func (o optional[T]) get(key string) (T, error) {
+	if !o.set {
+		var zero T
+		return zero, fmt.Errorf("%w: %s", ErrAttrNotSet, key)
+	}
+	return o.value, nil
+}
+
+// attrReader reads optional attributes from a map of attributes. Absent attributes stay unset,
+// attributes of unexpected kind and malformed timestamps stay unset as well but are collected as
+// warnings, since they indicate a change of the api schema.
+type attrReader struct {
+	in       map[string]any
+	warnings []string
+}
+
+func newAttrReader(in map[string]any) *attrReader {
+	return &attrReader{in: in}
+}
+
+// timestamps returns the times the attributes were reported, which are passed as map of RFC 3339
+// strings with the attribute name as key under the key AttrTimestamps. Malformed timestamps are
+// left out like missing ones and collected as warnings.
+func (r *attrReader) timestamps() map[string]time.Time {
+	raw, ok := r.in[AttrTimestamps].(map[string]string)
+	if !ok {
+		return nil
+	}
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	ts := make(map[string]time.Time, len(raw))
+	for _, k := range keys {
+		t, err := time.Parse(time.RFC3339, raw[k])
+		if err != nil {
+			r.warnings = append(r.warnings, fmt.Sprintf("timestamp of attribute '%s' is malformed: %v", k, err))
+			continue
+		}
+		ts[k] = t
+	}
+	return ts
+}
+
+func (r *attrReader) float(key string) optional[float64] {
+	if r.in[key] == nil {
+		return optional[float64]{}
+	}
+	f, err := floatFromVal(reflect.ValueOf(r.in[key]))
+	if err != nil {
+		r.warn(key, err)
+		return optional[float64]{}
+	}
+	return optional[float64]{value: f, set: true}
+}
+
+func (r *attrReader) str(key string) optional[string] {
+	if r.in[key] == nil {
+		return optional[string]{}
+	}
+	str, err := strFromVal(reflect.ValueOf(r.in[key]))
+	if err != nil {
+		r.warn(key, err)
+		return optional[string]{}
+	}
+	return optional[string]{value: str, set: true}
+}
+
+// time reads an attribute reported as RFC 3339 string
+func (r *attrReader) time(key string) optional[time.Time] {
+	str := r.str(key)
+	if !str.set {
+		return optional[time.Time]{}
+	}
+	t, err := time.Parse(time.RFC3339, str.value)
+	if err != nil {
+		r.warn(key, err)
+		return optional[time.Time]{}
+	}
+	return optional[time.Time]{value: t, set: true}
+}
+
+func (r *attrReader) strs(key string) []string {
+	if r.in[key] == nil {
+		return nil
+	}
+	strs, err := strsFromVal(reflect.ValueOf(r.in[key]))
+	if err != nil {
+		r.warn(key, err)
+		return nil
+	}
+	return strs
+}
+
+func (r *attrReader) warn(key string, err error) {
+	r.warnings = append(r.warnings, fmt.Sprintf("attribute '%s' has unexpected kind: %v", key, err))
+}
@@ -3,6 +3,7 @@ package device
 import (
 	"fmt"
 	"reflect"
+	"time"
 )
 
 const (
@@ -16,25 +17,28 @@ const (
 	AttrSerial       = "serial"
 	AttrModelType    = "modelType"
 	AttrRFLinkState  = "rfLinkState"
+	AttrTimestamps   = "timestamps"
 )
 
 type Common struct {
 	id           string
-	name         string
-	batteryLevel float64
-	batteryState string
-	rfLinkLevel  float64
-	serial       string
-	modelType    string
-	rfLinkState  string
+	name         optional[string]
+	batteryLevel optional[float64]
+	batteryState optional[string]
+	rfLinkLevel  optional[float64]
+	serial       optional[string]
+	modelType    optional[string]
+	rfLinkState  optional[string]
+	timestamps   map[string]time.Time
+	warnings     []string
 }
 
 func (c Common) getFloatAttr(key string) (float64, error) {
 	switch key {
 	case AttrBatteryLevel:
-		return c.batteryLevel, nil
+		return c.batteryLevel.get(key)
 	case AttrRFLinkLevel:
-		return c.rfLinkLevel, nil
+		return c.rfLinkLevel.get(key)
 	default:
 		return 0, fmt.Errorf("unsupported float attribute %s", key)
 	}
@@ -43,61 +47,53 @@ func (c Common) getFloatAttr(key string) (float64, error) {
 func (c Common) getStrAttr(key string) (string, error) {
 	switch key {
 	case AttrName:
-		return c.name, nil
+		return c.name.get(key)
 	case AttrBatteryState:
-		return c.batteryState, nil
+		return c.batteryState.get(key)
 	case AttrSerial:
-		return c.serial, nil
+		return c.serial.get(key)
 	case AttrModelType:
-		return c.modelType, nil
+		return c.modelType.get(key)
 	case AttrRFLinkState:
-		return c.rfLinkState, nil
+		return c.rfLinkState.get(key)
 	default:
 		return "", fmt.Errorf("unsuppported string attribute %s", key)
 	}
 }
 
-func commonFrom(in map[string]any) (Common, error) {
+// getAttrTimestamp returns the time the attribute with the given key was last updated.
+// It returns ErrAttrNotSet if the attribute was reported without timestamp.
+func (c Common) getAttrTimestamp(key string) (time.Time, error) {
+	ts, ok := c.timestamps[key]
+	if !ok {
+		return time.Time{}, fmt.Errorf("%w: timestamp of %s", ErrAttrNotSet, key)
+	}
+	return ts, nil
+}
+
+// getWarnings returns the warnings collected while reading the attributes of a device
+func (c Common) getWarnings() []string {
+	return append([]string{}, c.warnings...)
+}
+
+// commonFrom reads the common attributes from the given attribute reader. Only the id is
+// required, all other attributes are optional since not every device reports them,
+// e.g. mains powered devices have no battery.
+func commonFrom(r *attrReader) (Common, error) {
 	var c Common
-	str, err := strFromVal(reflect.ValueOf(in[AttrId]))
+	str, err := strFromVal(reflect.ValueOf(r.in[AttrId]))
 	if err != nil {
-		return Common{}, fmt.Errorf("unable to get attr '%s' from map %v, got err:\n%w", AttrId, in, err)
+		return Common{}, fmt.Errorf("unable to get attr '%s' from map %v, got err:\n%w", AttrId, r.in, err)
 	}
 	c.id = str
-	str, err = strFromVal(reflect.ValueOf(in[AttrName]))
-	if err != nil {
-		return Common{}, fmt.Errorf("unable to get attr '%s' from map %v, got err:\n%w", AttrName, in, err)
-	}
-	c.name = str
-	f, err := floatFromVal(reflect.ValueOf(in[AttrBatteryLevel]))
-	if err != nil {
-		return Common{}, fmt.Errorf("unable to get attr '%s' from map %v, got err:\n%w", AttrBatteryLevel, in, err)
-	}
-	c.batteryLevel = f
-	str, err = strFromVal(reflect.ValueOf(in[AttrBatteryState]))
-	if err != nil {
-		return Common{}, fmt.Errorf("unable to get attr '%s' from map %v, got err:\n%w", AttrBatteryState, in, err)
-	}
-	c.batteryState = str
-	f, err = floatFromVal(reflect.ValueOf(in[AttrRFLinkLevel]))
-	if err != nil {
-		return Common{}, fmt.Errorf("unable to get attr '%s' from map %v, got err:\n%w", AttrRFLinkLevel, in, err)
-	}
-	c.rfLinkLevel = f
-	str, err = strFromVal(reflect.ValueOf(in[AttrSerial]))
-	if err != nil {
-		return Common{}, fmt.Errorf("unable to get attr '%s' from map %v, got err:\n%w", AttrSerial, in, err)
-	}
-	c.serial = str
-	str, err = strFromVal(reflect.ValueOf(in[AttrModelType]))
-	if err != nil {
-		return Common{}, fmt.Errorf("unable to get attr '%s' from map %v, got err:\n%w", AttrModelType, in, err)
-	}
-	c.modelType = str
-	str, err = strFromVal(reflect.ValueOf(in[AttrRFLinkState]))
-	if err != nil {
-		return Common{}, fmt.Errorf("unable to get attr '%s' from map %v, got err:\n%w", AttrRFLinkState, in, err)
-	}
-	c.rfLinkState = str
+	c.name = r.str(AttrName)
+	c.batteryLevel = r.float(AttrBatteryLevel)
+	c.batteryState = r.str(AttrBatteryState)
+	c.rfLinkLevel = r.float(AttrRFLinkLevel)
+	c.serial = r.str(AttrSerial)
+	c.modelType = r.str(AttrModelType)
+	c.rfLinkState = r.str(AttrRFLinkState)
+	c.timestamps = r.timestamps()
+	c.warnings = r.warnings
 	return c, nil
 }
@@ -1,23 +1,39 @@
 package device
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
+	"time"
 )
 
 const Type = "DEVICE"
 
+// ErrUnsupportedType is returned by the Factory for unknown device types
+var ErrUnsupportedType = errors.New("unsupported device type")
+
 type Device interface {
 	GetDeviceId() string
 	GetDeviceType() string
 	GetFloatAttr(key string) (float64, error)
 	GetStrAttr(key string) (string, error)
+	// GetAttrTimestamp returns the time the attribute with the given key was last updated
+	GetAttrTimestamp(key string) (time.Time, error)
+	// GetWarnings returns a description of each attribute that was reported with an
+	// unexpected kind or a malformed timestamp and therefore ignored
+	GetWarnings() []string
 }
 
+// Types are the types of all supported devices
+var Types = []string{TypeSensor, TypeMower, TypeValve, TypePowerSocket, TypeValveSet}
+
 // Factory create a gardena device from a given map of attributes. Currently supported
 // devices are:
 // - SENSOR
 // - MOWER
+// - VALVE
+// - POWER_SOCKET
+// - VALVE_SET
 func Factory(in map[string]any) (Device, error) {
 	switch in[AttrType] {
 	case TypeSensor:
@@ -32,8 +48,26 @@ func Factory(in map[string]any) (Device, error) {
 			return nil, fmt.Errorf("unable to create mower from %v, got err:\n%w", in, err)
 		}
 		return m, nil
+	case TypeValve:
+		v, err := ValveFrom(in)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create valve from %v, got err:\n%w", in, err)
+		}
+		return v, nil
+	case TypePowerSocket:
+		p, err := PowerSocketFrom(in)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create power socket from %v, got err:\n%w", in, err)
+		}
+		return p, nil
+	case TypeValveSet:
+		v, err := ValveSetFrom(in)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create valve set from %v, got err:\n%w", in, err)
+		}
+		return v, nil
 	default:
-		return nil, fmt.Errorf("unsupported device type %s", in[AttrType])
+		return nil, fmt.Errorf("%w %v", ErrUnsupportedType, in[AttrType])
 	}
 }
 
@@ -54,3 +88,24 @@ func strFromVal(in reflect.Value) (string, error) {
 	}
 	return in.String(), nil
 }
+
+// strsFromVal excepts a reflect.Value of kind Slice with string elements and returns the
+// values as string slice otherwise it returns an error
+func strsFromVal(in reflect.Value) ([]string, error) {
+	if in.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("excepted slice value, got %v", in)
+	}
+	strs := make([]string, 0, in.Len())
+	for i := 0; i < in.Len(); i++ {
+		v := in.Index(i)
+		if v.Kind() == reflect.Interface {
+			v = v.Elem()
+		}
+		str, err := strFromVal(v)
+		if err != nil {
+			return nil, err
+		}
+		strs = append(strs, str)
+	}
+	return strs, nil
+}
@@ -0,0 +1,126 @@
+package device
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFactoryWithMissingOptionalAttributes(t *testing.T) {
+	// mains powered sensor without battery and soil humidity
+	d, err := Factory(map[string]any{
+		AttrId:       "dev-1-id",
+		AttrType:     TypeSensor,
+		AttrName:     "Sensor01",
+		AttrSoilTemp: float64(21),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating sensor without optional attributes:\n%v", err)
+	}
+	if w := d.GetWarnings(); len(w) != 0 {
+		t.Fatalf("Expected no warnings for absent attributes, got %v", w)
+	}
+	for _, key := range []string{AttrBatteryLevel, AttrSoilHumidity, AttrLightIntensity, AttrAmbientTemp} {
+		if _, err := d.GetFloatAttr(key); !errors.Is(err, ErrAttrNotSet) {
+			t.Fatalf("Expected ErrAttrNotSet for absent attribute %s, got %v", key, err)
+		}
+	}
+	soT, err := d.GetFloatAttr(AttrSoilTemp)
+	if err != nil || soT != 21 {
+		t.Fatalf("Expected soil temperature of 21, got %v, err %v", soT, err)
+	}
+}
+
+func TestFactoryWithUnexpectedAttributeKind(t *testing.T) {
+	d, err := Factory(map[string]any{
+		AttrId:             "dev-2-id",
+		AttrType:           TypeMower,
+		AttrActivity:       "PARKED_TIMER",
+		AttrOperatingHours: "435",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating mower with malformed attribute:\n%v", err)
+	}
+	if w := d.GetWarnings(); len(w) != 1 {
+		t.Fatalf("Expected one warning for the malformed operating hours, got %v", w)
+	}
+	if _, err := d.GetFloatAttr(AttrOperatingHours); !errors.Is(err, ErrAttrNotSet) {
+		t.Fatalf("Expected malformed operating hours to be unset, got %v", err)
+	}
+	a, err := d.GetStrAttr(AttrActivity)
+	if err != nil || a != "PARKED_TIMER" {
+		t.Fatalf("Expected activity PARKED_TIMER, got %v, err %v", a, err)
+	}
+}
+
+func TestPowerSocketFrom(t *testing.T) {
+	// mains powered, so without battery level
+	p, err := PowerSocketFrom(map[string]any{
+		AttrId:           "dev-4-id",
+		AttrType:         TypePowerSocket,
+		AttrName:         "Pond Pump",
+		AttrBatteryState: "NO_BATTERY",
+		AttrActivity:     "TIME_LIMITED_ON",
+		AttrDuration:     float64(3600),
+	})
+	if err != nil {
+		t.Fatalf("Unable to create power socket, got err:\n%v", err)
+	}
+	if w := p.GetWarnings(); len(w) != 0 {
+		t.Fatalf("Expected no warnings, got %v", w)
+	}
+	if _, err := p.GetFloatAttr(AttrBatteryLevel); !errors.Is(err, ErrAttrNotSet) {
+		t.Fatalf("Expected no battery level, got err %v", err)
+	}
+	if d, err := p.GetFloatAttr(AttrDuration); err != nil || d != 3600 {
+		t.Fatalf("Expected duration of 3600, got %v, err %v", d, err)
+	}
+
+	for activity, on := range map[string]bool{
+		"FOREVER_ON":      true,
+		"TIME_LIMITED_ON": true,
+		"SCHEDULED_ON":    true,
+		ActivityOff:       false,
+	} {
+		p, err := PowerSocketFrom(map[string]any{AttrId: "dev-4-id", AttrActivity: activity})
+		if err != nil || p.IsOn() != on {
+			t.Fatalf("Expected power socket with activity %s to be on %v, got %v, err %v", activity, on, p.IsOn(), err)
+		}
+	}
+	if p, _ := PowerSocketFrom(map[string]any{AttrId: "dev-4-id"}); p.IsOn() {
+		t.Fatalf("Expected power socket without activity to be off")
+	}
+}
+
+func TestMowerLastErrorTimestamp(t *testing.T) {
+	reported := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+	mower := func(code string) Mower {
+		m, err := MowerFrom(map[string]any{
+			AttrId:            "dev-2-id",
+			AttrType:          TypeMower,
+			AttrLastErrorCode: code,
+			AttrTimestamps:    map[string]string{AttrLastErrorCode: reported.Format(time.RFC3339)},
+		})
+		if err != nil {
+			t.Fatalf("Unable to create mower, got err:\n%v", err)
+		}
+		return m
+	}
+
+	if ts, err := mower("TRAPPED").GetLastErrorTimestamp(); err != nil || !ts.Equal(reported) {
+		t.Fatalf("Expected time the error code was reported, got %v, err %v", ts, err)
+	}
+	// the time an error was cleared is no error
+	if ts, err := mower(ErrorCodeNoMessage).GetLastErrorTimestamp(); !errors.Is(err, ErrAttrNotSet) {
+		t.Fatalf("Expected no error timestamp without error, got %v, err %v", ts, err)
+	}
+}
+
+func TestFactoryErrors(t *testing.T) {
+	if _, err := Factory(map[string]any{AttrId: "gw-id", AttrType: "GATEWAY"}); !errors.Is(err, ErrUnsupportedType) {
+		t.Fatalf("Expected ErrUnsupportedType, got %v", err)
+	}
+	if _, err := Factory(map[string]any{AttrType: TypeSensor}); err == nil {
+		t.Fatalf("Expected error for device without id")
+	}
+}
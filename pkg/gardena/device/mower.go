@@ -2,7 +2,7 @@ package device
 
 import (
 	"fmt"
-	"reflect"
+	"time"
 )
 
 const (
@@ -10,12 +10,16 @@ const (
 	AttrState          = "state"
 	AttrActivity       = "activity"
 	AttrOperatingHours = "operatingHours"
+
+	AttrLastErrorCodeTimestamp = "lastErrorCodeTimestamp"
 )
 
 type Mower struct {
-	state          string
-	activity       string
-	operatingHours float64
+	state          optional[string]
+	activity       optional[string]
+	operatingHours optional[float64]
+	lastErrorCode  optional[string]
+	lastErrorTime  optional[time.Time]
 	common         Common
 }
 
@@ -26,7 +30,7 @@ func (m Mower) GetDeviceId() string {
 func (m Mower) GetFloatAttr(key string) (float64, error) {
 	switch key {
 	case AttrOperatingHours:
-		return m.operatingHours, nil
+		return m.operatingHours.get(key)
 	default:
 		return m.common.getFloatAttr(key)
 	}
@@ -35,9 +39,11 @@ func (m Mower) GetFloatAttr(key string) (float64, error) {
 func (m Mower) GetStrAttr(key string) (string, error) {
 	switch key {
 	case AttrState:
-		return m.state, nil
+		return m.state.get(key)
 	case AttrActivity:
-		return m.activity, nil
+		return m.activity.get(key)
+	case AttrLastErrorCode:
+		return m.lastErrorCode.get(key)
 	default:
 		return m.common.getStrAttr(key)
 	}
@@ -47,28 +53,45 @@ func (m Mower) GetDeviceType() string {
 	return TypeMower
 }
 
+func (m Mower) GetAttrTimestamp(key string) (time.Time, error) {
+	return m.common.getAttrTimestamp(key)
+}
+
+// HasError returns true if the mower reports an error code other than NO_MESSAGE
+func (m Mower) HasError() bool {
+	return m.lastErrorCode.set && m.lastErrorCode.value != ErrorCodeNoMessage
+}
+
+// GetLastErrorTimestamp returns the time the last error occurred. It's either reported as
+// attribute lastErrorCodeTimestamp or as timestamp of the attribute lastErrorCode. The latter is
+// only used while the mower has an error, since a lastErrorCode NO_MESSAGE is reported when the
+// error was cleared.
+func (m Mower) GetLastErrorTimestamp() (time.Time, error) {
+	if m.lastErrorTime.set {
+		return m.lastErrorTime.value, nil
+	}
+	if !m.HasError() {
+		return time.Time{}, fmt.Errorf("%w: %s", ErrAttrNotSet, AttrLastErrorCodeTimestamp)
+	}
+	return m.common.getAttrTimestamp(AttrLastErrorCode)
+}
+
+func (m Mower) GetWarnings() []string {
+	return m.common.getWarnings()
+}
+
 // MowerFrom creates a Mower af a map of attributes. Attribute values are excepted to be
 // interfaces that can be converted with th device.xFromVal methods.
-// If a value is of unexpected kind an error is returned.
+// Absent attributes stay unset, values of unexpected kind are reported as warnings.
 func MowerFrom(in map[string]any) (Mower, error) {
 	var m Mower
-	var f float64
-	str, err := strFromVal(reflect.ValueOf(in[AttrState]))
-	if err != nil {
-		return Mower{}, fmt.Errorf("unable to get attr '%s' from map %v, got err:\n%w", AttrState, in, err)
-	}
-	m.state = str
-	str, err = strFromVal(reflect.ValueOf(in[AttrActivity]))
-	if err != nil {
-		return Mower{}, fmt.Errorf("unable to get attr '%s' from map %v, got err:\n%w", AttrActivity, in, err)
-	}
-	m.activity = str
-	f, err = floatFromVal(reflect.ValueOf(in[AttrOperatingHours]))
-	if err != nil {
-		return Mower{}, fmt.Errorf("unable to get attr '%s' from map %v, got err:\n%w", AttrOperatingHours, in, err)
-	}
-	m.operatingHours = f
-	c, err := commonFrom(in)
+	r := newAttrReader(in)
+	m.state = r.str(AttrState)
+	m.activity = r.str(AttrActivity)
+	m.operatingHours = r.float(AttrOperatingHours)
+	m.lastErrorCode = r.str(AttrLastErrorCode)
+	m.lastErrorTime = r.time(AttrLastErrorCodeTimestamp)
+	c, err := commonFrom(r)
 	if err != nil {
 		return Mower{}, fmt.Errorf("unable to generate common attributes, got err:\n%w", err)
 	}
@@ -0,0 +1,84 @@
+package device
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	TypePowerSocket = "POWER_SOCKET"
+
+	ActivityOff = "OFF"
+)
+
+// PowerSocket is the smart Power Adapter, switching a connected device on or off
+type PowerSocket struct {
+	activity      optional[string]
+	state         optional[string]
+	duration      optional[float64]
+	lastErrorCode optional[string]
+	common        Common
+}
+
+func (p PowerSocket) GetDeviceId() string {
+	return p.common.id
+}
+
+func (p PowerSocket) GetFloatAttr(key string) (float64, error) {
+	switch key {
+	case AttrDuration:
+		return p.duration.get(key)
+	default:
+		return p.common.getFloatAttr(key)
+	}
+}
+
+func (p PowerSocket) GetStrAttr(key string) (string, error) {
+	switch key {
+	case AttrActivity:
+		return p.activity.get(key)
+	case AttrState:
+		return p.state.get(key)
+	case AttrLastErrorCode:
+		return p.lastErrorCode.get(key)
+	default:
+		return p.common.getStrAttr(key)
+	}
+}
+
+func (p PowerSocket) GetDeviceType() string {
+	return TypePowerSocket
+}
+
+func (p PowerSocket) GetAttrTimestamp(key string) (time.Time, error) {
+	return p.common.getAttrTimestamp(key)
+}
+
+func (p PowerSocket) GetWarnings() []string {
+	return p.common.getWarnings()
+}
+
+// IsOn returns true if the power socket is currently switched on, either
+// manually, time limited or by schedule
+func (p PowerSocket) IsOn() bool {
+	return p.activity.set && p.activity.value != ActivityOff
+}
+
+// PowerSocketFrom creates a PowerSocket af a map of attributes. Attribute values are excepted to be
+// interfaces that can be converted with th device.xFromVal methods.
+// Absent attributes stay unset, values of unexpected kind are reported as warnings. The
+// duration is only reported while switched on time limited.
+func PowerSocketFrom(in map[string]any) (PowerSocket, error) {
+	var p PowerSocket
+	r := newAttrReader(in)
+	p.activity = r.str(AttrActivity)
+	p.state = r.str(AttrState)
+	p.duration = r.float(AttrDuration)
+	p.lastErrorCode = r.str(AttrLastErrorCode)
+	c, err := commonFrom(r)
+	if err != nil {
+		return PowerSocket{}, fmt.Errorf("unable to generate common attributes, got err:\n%w", err)
+	}
+	p.common = c
+	return p, nil
+}
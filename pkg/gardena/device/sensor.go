@@ -2,18 +2,22 @@ package device
 
 import (
 	"fmt"
-	"reflect"
+	"time"
 )
 
 const (
-	TypeSensor       = "SENSOR"
-	AttrSoilHumidity = "soilHumidity"
-	AttrSoilTemp     = "soilTemperature"
+	TypeSensor         = "SENSOR"
+	AttrSoilHumidity   = "soilHumidity"
+	AttrSoilTemp       = "soilTemperature"
+	AttrLightIntensity = "lightIntensity"
+	AttrAmbientTemp    = "ambientTemperature"
 )
 
 type Sensor struct {
-	soilHumidity    float64
-	soilTemperature float64
+	soilHumidity    optional[float64]
+	soilTemperature optional[float64]
+	lightIntensity  optional[float64]
+	ambientTemp     optional[float64]
 	common          Common
 }
 
@@ -24,9 +28,13 @@ func (s Sensor) GetDeviceId() string {
 func (s Sensor) GetFloatAttr(key string) (float64, error) {
 	switch key {
 	case AttrSoilHumidity:
-		return s.soilHumidity, nil
+		return s.soilHumidity.get(key)
 	case AttrSoilTemp:
-		return s.soilTemperature, nil
+		return s.soilTemperature.get(key)
+	case AttrLightIntensity:
+		return s.lightIntensity.get(key)
+	case AttrAmbientTemp:
+		return s.ambientTemp.get(key)
 	default:
 		return s.common.getFloatAttr(key)
 	}
@@ -41,22 +49,26 @@ func (s Sensor) GetDeviceType() string {
 	return TypeSensor
 }
 
+func (s Sensor) GetAttrTimestamp(key string) (time.Time, error) {
+	return s.common.getAttrTimestamp(key)
+}
+
+func (s Sensor) GetWarnings() []string {
+	return s.common.getWarnings()
+}
+
 // SensorFrom creates a Sensor af a map of attributes. Attribute values are excepted to be
 // interfaces that can be converted with th device.xFromVal methods.
-// If a value is of unexpected kind an error is returned.
+// Absent attributes stay unset, values of unexpected kind are reported as warnings. Light
+// intensity and ambient temperature are only reported by some sensor models.
 func SensorFrom(in map[string]any) (Sensor, error) {
 	var s Sensor
-	f, err := floatFromVal(reflect.ValueOf(in[AttrSoilHumidity]))
-	if err != nil {
-		return Sensor{}, fmt.Errorf("unable to get attr '%s' from map %v, got err:\n%w", AttrSoilHumidity, in, err)
-	}
-	s.soilHumidity = f
-	f, err = floatFromVal(reflect.ValueOf(in[AttrSoilTemp]))
-	if err != nil {
-		return Sensor{}, fmt.Errorf("unable to get attr '%s' from map %v, got err:\n%w", AttrSoilTemp, in, err)
-	}
-	s.soilTemperature = f
-	c, err := commonFrom(in)
+	r := newAttrReader(in)
+	s.soilHumidity = r.float(AttrSoilHumidity)
+	s.soilTemperature = r.float(AttrSoilTemp)
+	s.lightIntensity = r.float(AttrLightIntensity)
+	s.ambientTemp = r.float(AttrAmbientTemp)
+	c, err := commonFrom(r)
 	if err != nil {
 		return Sensor{}, fmt.Errorf("unable to generate common attributes, got err:\n%w", err)
 	}
@@ -0,0 +1,96 @@
+package device
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	TypeValve         = "VALVE"
+	AttrDuration      = "duration"
+	AttrLastErrorCode = "lastErrorCode"
+
+	ActivityClosed = "CLOSED"
+)
+
+// Valve is a single valve of a smart Water Control or smart Irrigation Control. Multi valve
+// controllers report each valve as own service with the id '<device id>:<valve number>'.
+type Valve struct {
+	activity      optional[string]
+	state         optional[string]
+	duration      optional[float64]
+	lastErrorCode optional[string]
+	valveSetId    optional[string]
+	common        Common
+}
+
+func (v Valve) GetDeviceId() string {
+	return v.common.id
+}
+
+func (v Valve) GetFloatAttr(key string) (float64, error) {
+	switch key {
+	case AttrDuration:
+		return v.duration.get(key)
+	default:
+		return v.common.getFloatAttr(key)
+	}
+}
+
+func (v Valve) GetStrAttr(key string) (string, error) {
+	switch key {
+	case AttrActivity:
+		return v.activity.get(key)
+	case AttrState:
+		return v.state.get(key)
+	case AttrLastErrorCode:
+		return v.lastErrorCode.get(key)
+	case AttrValveSetId:
+		return v.valveSetId.get(key)
+	default:
+		return v.common.getStrAttr(key)
+	}
+}
+
+func (v Valve) GetDeviceType() string {
+	return TypeValve
+}
+
+func (v Valve) GetAttrTimestamp(key string) (time.Time, error) {
+	return v.common.getAttrTimestamp(key)
+}
+
+func (v Valve) GetWarnings() []string {
+	return v.common.getWarnings()
+}
+
+// GetValveSetId returns the id of the valve set the valve belongs to or
+// an empty string if the valve isn't controlled by a valve set
+func (v Valve) GetValveSetId() string {
+	return v.valveSetId.value
+}
+
+// IsOpen returns true if the valve is currently watering
+func (v Valve) IsOpen() bool {
+	return v.activity.set && v.activity.value != ActivityClosed
+}
+
+// ValveFrom creates a Valve af a map of attributes. Attribute values are excepted to be
+// interfaces that can be converted with th device.xFromVal methods.
+// Absent attributes stay unset, values of unexpected kind are reported as warnings. The
+// duration is only reported while watering, the id of the parent valve set is added by the store.
+func ValveFrom(in map[string]any) (Valve, error) {
+	var v Valve
+	r := newAttrReader(in)
+	v.activity = r.str(AttrActivity)
+	v.state = r.str(AttrState)
+	v.duration = r.float(AttrDuration)
+	v.lastErrorCode = r.str(AttrLastErrorCode)
+	v.valveSetId = r.str(AttrValveSetId)
+	c, err := commonFrom(r)
+	if err != nil {
+		return Valve{}, fmt.Errorf("unable to generate common attributes, got err:\n%w", err)
+	}
+	v.common = c
+	return v, nil
+}
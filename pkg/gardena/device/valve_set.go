@@ -0,0 +1,85 @@
+package device
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	TypeValveSet   = "VALVE_SET"
+	AttrValveIds   = "valveIds"
+	AttrValveSetId = "valveSetId"
+
+	StateOK            = "OK"
+	ErrorCodeNoMessage = "NO_MESSAGE"
+)
+
+// ValveSet is the controller of multiple valves, like the smart Irrigation Control. It
+// reports the state of the controller itself, the state of each valve is reported by the
+// Valve children, which are linked by their ids.
+type ValveSet struct {
+	state         optional[string]
+	lastErrorCode optional[string]
+	valveIds      []string
+	common        Common
+}
+
+func (v ValveSet) GetDeviceId() string {
+	return v.common.id
+}
+
+func (v ValveSet) GetFloatAttr(key string) (float64, error) {
+	// has no float attributes itself
+	return v.common.getFloatAttr(key)
+}
+
+func (v ValveSet) GetStrAttr(key string) (string, error) {
+	switch key {
+	case AttrState:
+		return v.state.get(key)
+	case AttrLastErrorCode:
+		return v.lastErrorCode.get(key)
+	default:
+		return v.common.getStrAttr(key)
+	}
+}
+
+func (v ValveSet) GetDeviceType() string {
+	return TypeValveSet
+}
+
+func (v ValveSet) GetAttrTimestamp(key string) (time.Time, error) {
+	return v.common.getAttrTimestamp(key)
+}
+
+func (v ValveSet) GetWarnings() []string {
+	return v.common.getWarnings()
+}
+
+// GetValveIds returns the ids of all valves controlled by the valve set
+func (v ValveSet) GetValveIds() []string {
+	return append([]string{}, v.valveIds...)
+}
+
+// IsHealthy returns true if the valve set reports state OK and no error
+func (v ValveSet) IsHealthy() bool {
+	return v.state.value == StateOK && (!v.lastErrorCode.set || v.lastErrorCode.value == ErrorCodeNoMessage)
+}
+
+// ValveSetFrom creates a ValveSet af a map of attributes. Attribute values are excepted to be
+// interfaces that can be converted with th device.xFromVal methods.
+// Absent attributes stay unset, values of unexpected kind are reported as warnings. The
+// ids of the valves are added by the store.
+func ValveSetFrom(in map[string]any) (ValveSet, error) {
+	var v ValveSet
+	r := newAttrReader(in)
+	v.state = r.str(AttrState)
+	v.lastErrorCode = r.str(AttrLastErrorCode)
+	v.valveIds = r.strs(AttrValveIds)
+	c, err := commonFrom(r)
+	if err != nil {
+		return ValveSet{}, fmt.Errorf("unable to generate common attributes, got err:\n%w", err)
+	}
+	v.common = c
+	return v, nil
+}
@@ -0,0 +1,151 @@
+package gardena
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Sentinel errors of the api, use errors.Is to check a returned error against them.
+// Errors of responses with an unexpected status code are returned as *StatusError,
+// responses that can't be decoded as *DecodeError.
+var (
+	// ErrUnauthorized is returned if the api rejects the access token or the client credentials
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden is returned if the credentials don't grant access to a resource
+	ErrForbidden = errors.New("forbidden")
+	// ErrNotFound is returned if a resource, e.g. a location or service, doesn't exist
+	ErrNotFound = errors.New("not found")
+	// ErrRateLimited is returned if the api keeps answering with 429 Too Many Requests, or asks to wait
+	// longer than the client is willing to block a request
+	ErrRateLimited = errors.New("rate limited by api")
+	// ErrServerError is returned if the api answers with a 5xx status code
+	ErrServerError = errors.New("server error")
+	// ErrDecode is returned if a response can't be decoded
+	ErrDecode = errors.New("unable to decode response")
+)
+
+// JSONAPIError is a single error object of a JSON:API error response
+type JSONAPIError struct {
+	Id     string `json:"id"`
+	Status string `json:"status"`
+	Code   string `json:"code"`
+	Title  string `json:"title"`
+	Detail string `json:"detail"`
+}
+
+func (e JSONAPIError) String() string {
+	parts := make([]string, 0, 3)
+	for _, p := range []string{e.Code, e.Title, e.Detail} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, ": ")
+}
+
+type jsonAPIErrors struct {
+	Errors []JSONAPIError `json:"errors"`
+}
+
+// StatusError is returned if an endpoint answers with an unexpected status code. It matches
+// the sentinel error of its status code, e.g. errors.Is(err, ErrNotFound) for 404.
+type StatusError struct {
+	StatusCode int
+	// Endpoint is the method and url of the request, e.g. GET https://api.smart.gardena.dev/v1/locations
+	Endpoint string
+	// Errors are the JSON:API errors of the response
+	Errors []JSONAPIError
+	// Body is the response body if it contains no JSON:API errors
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	msg := e.Body
+	if len(e.Errors) > 0 {
+		msgs := make([]string, 0, len(e.Errors))
+		for _, err := range e.Errors {
+			msgs = append(msgs, err.String())
+		}
+		msg = strings.Join(msgs, ", ")
+	}
+	return fmt.Sprintf("%s answered with status code %d: %s", e.Endpoint, e.StatusCode, msg)
+}
+
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrServerError:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// DecodeError is returned if the response of an endpoint can't be decoded. It matches ErrDecode
+// and unwraps to the error of the decoder.
+type DecodeError struct {
+	StatusCode int
+	Endpoint   string
+	Err        error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("unable to decode response of %s with status code %d, got err: %v", e.Endpoint, e.StatusCode, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DecodeError) Is(target error) bool {
+	return target == ErrDecode
+}
+
+// newStatusError creates a StatusError of the given response, reading the JSON:API errors of its body
+func newStatusError(res *http.Response) *StatusError {
+	e := &StatusError{StatusCode: res.StatusCode, Endpoint: endpointOf(res)}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		e.Body = fmt.Sprintf("unable to read response, got err: %v", err)
+		return e
+	}
+	var errs jsonAPIErrors
+	if err := json.Unmarshal(b, &errs); err != nil || len(errs.Errors) == 0 {
+		e.Body = string(b)
+		return e
+	}
+	e.Errors = errs.Errors
+	return e
+}
+
+// decodeResponse reads the body of the given response and decodes it into v
+func decodeResponse(res *http.Response, v any) error {
+	b, err := io.ReadAll(res.Body)
+	if err == nil {
+		err = json.Unmarshal(b, v)
+	}
+	if err != nil {
+		return &DecodeError{StatusCode: res.StatusCode, Endpoint: endpointOf(res), Err: err}
+	}
+	return nil
+}
+
+// endpointOf returns the method and url of the request of the given response
+func endpointOf(res *http.Response) string {
+	if res.Request == nil || res.Request.URL == nil {
+		return "unknown endpoint"
+	}
+	u := *res.Request.URL
+	u.RawQuery = ""
+	return res.Request.Method + " " + u.String()
+}
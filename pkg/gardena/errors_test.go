@@ -0,0 +1,62 @@
+package gardena
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case LocationsURL + "/unknown":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors": [{"status": "404", "code": "LOCATION_NOT_FOUND", "title": "Location not found"}]}`))
+		case LocationsURL + "/broken":
+			w.Write([]byte(`{"data": `))
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(`upstream unavailable`))
+		}
+	}))
+	defer server.Close()
+	api := API{baseURL: server.URL, httpClient: &http.Client{}}
+
+	_, err := api.GetInitialStateFor(Location{Id: "unknown"})
+	var statusErr *StatusError
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &statusErr) {
+		t.Fatalf("Expected not found status error, got %v", err)
+	}
+	if statusErr.StatusCode != http.StatusNotFound || statusErr.Endpoint != "GET "+server.URL+LocationsURL+"/unknown" ||
+		len(statusErr.Errors) != 1 || statusErr.Errors[0].Code != "LOCATION_NOT_FOUND" {
+		t.Fatalf("Unexpected status error %+v", statusErr)
+	}
+	if errors.Is(err, ErrServerError) || errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("Expected not found error to match no other sentinel, got %v", err)
+	}
+
+	_, err = api.GetInitialStateFor(Location{Id: "broken"})
+	var decodeErr *DecodeError
+	if !errors.Is(err, ErrDecode) || !errors.As(err, &decodeErr) || decodeErr.StatusCode != http.StatusOK {
+		t.Fatalf("Expected decode error, got %v", err)
+	}
+
+	_, err = api.GetLocations()
+	if !errors.Is(err, ErrServerError) || !errors.As(err, &statusErr) || statusErr.Body != "upstream unavailable" {
+		t.Fatalf("Expected server error with body, got %v", err)
+	}
+}
+
+func TestAuthenticationErrors(t *testing.T) {
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"errors": [{"code": "invalid_client", "detail": "client secret is invalid"}]}`))
+	}))
+	defer authServer.Close()
+
+	api := API{authUrl: authServer.URL, httpClient: &http.Client{}, clientID: "abc", clientSecret: "def"}
+	if err := api.authenticate(); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("Expected %v, got %v", ErrUnauthorized, err)
+	}
+}
@@ -0,0 +1,191 @@
+// Package fake provides a gardena.Client serving fixed location states, to test code depending
+// on the gardena api without network access.
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// DefaultBaseURL is the base url reported by a Client, unless changed with SetBaseURL
+const DefaultBaseURL = "http://gardena.fake/v1"
+
+// SentCommand is a command sent with a Client
+type SentCommand struct {
+	ServiceId string
+	Command   gardena.Command
+}
+
+// Client is a gardena.Client serving the states it was given. Sent commands are validated and
+// recorded, they don't change the states. A Client is safe for concurrent use.
+type Client struct {
+	mu           sync.Mutex
+	states       []gardena.State
+	commands     []SentCommand
+	err          error
+	baseURL      string
+	websocketURL string
+}
+
+var _ gardena.Client = (*Client)(nil)
+
+// NewClient creates a Client serving the given states, one per location
+func NewClient(states ...gardena.State) *Client {
+	c := &Client{baseURL: DefaultBaseURL}
+	for _, s := range states {
+		c.SetState(s)
+	}
+	return c
+}
+
+// NewClientFromFiles creates a Client serving the states of the given files, e.g. test/location.json
+func NewClientFromFiles(paths ...string) (*Client, error) {
+	c := NewClient()
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read state file %s, got err:\n%w", p, err)
+		}
+		var s gardena.State
+		if err := json.Unmarshal(b, &s); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal state file %s, got err:\n%w", p, err)
+		}
+		c.SetState(s)
+	}
+	return c, nil
+}
+
+// SetState sets the state of a location, replacing a previous state of the same location
+func (c *Client) SetState(s gardena.State) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.states {
+		if c.states[i].Data.Id == s.Data.Id {
+			c.states[i] = s
+			return
+		}
+	}
+	c.states = append(c.states, s)
+}
+
+// RemoveLocation removes the state of the location with the given id
+func (c *Client) RemoveLocation(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.states {
+		if c.states[i].Data.Id == id {
+			c.states = append(c.states[:i], c.states[i+1:]...)
+			return
+		}
+	}
+}
+
+// SetError sets an error returned by every call of the Client, nil resets it
+func (c *Client) SetError(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+// SetBaseURL sets the base url reported by the Client
+func (c *Client) SetBaseURL(u string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.baseURL = u
+}
+
+// SetWebsocketURL sets the websocket url returned for every location. Without websocket url,
+// requesting one fails.
+func (c *Client) SetWebsocketURL(u string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.websocketURL = u
+}
+
+// Commands returns the commands sent with the Client
+func (c *Client) Commands() []SentCommand {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]SentCommand{}, c.commands...)
+}
+
+func (c *Client) GetLocationsWithContext(ctx context.Context) (*gardena.Locations, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.check(ctx); err != nil {
+		return nil, err
+	}
+	var locations gardena.Locations
+	for _, s := range c.states {
+		l := gardena.Location{Id: s.Data.Id, Type: s.Data.Type, Attributes: s.Data.Attributes}
+		locations.Data = append(locations.Data, struct{ gardena.Location }{l})
+	}
+	return &locations, nil
+}
+
+func (c *Client) GetInitialStateForWithContext(ctx context.Context, location gardena.Location) (*gardena.State, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.check(ctx); err != nil {
+		return nil, err
+	}
+	for _, s := range c.states {
+		if s.Data.Id == location.Id {
+			return &s, nil
+		}
+	}
+	return nil, c.notFound("GET", gardena.LocationsURL+"/"+location.Id)
+}
+
+func (c *Client) GetWebsocketURLWithContext(ctx context.Context, location gardena.Location) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.check(ctx); err != nil {
+		return "", err
+	}
+	if c.websocketURL == "" {
+		return "", errors.New("fake client has no websocket url")
+	}
+	return c.websocketURL, nil
+}
+
+func (c *Client) SendCommandWithContext(ctx context.Context, serviceId string, cmd gardena.Command) (*gardena.CommandResult, error) {
+	if err := cmd.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid command for service %s, got err:\n%w", serviceId, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.check(ctx); err != nil {
+		return nil, err
+	}
+	c.commands = append(c.commands, SentCommand{ServiceId: serviceId, Command: cmd})
+	return &gardena.CommandResult{RequestId: fmt.Sprintf("fake-request-%d", len(c.commands)), StatusCode: http.StatusAccepted}, nil
+}
+
+func (c *Client) GetAPIHealthURL() string {
+	return c.GetBaseURL() + gardena.ApiHealthURL
+}
+
+func (c *Client) GetBaseURL() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.baseURL
+}
+
+// check returns the configured error or the error of a canceled context. The caller must hold mu.
+func (c *Client) check(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.err
+}
+
+func (c *Client) notFound(method, path string) error {
+	return &gardena.StatusError{StatusCode: http.StatusNotFound, Endpoint: method + " " + c.baseURL + path}
+}
@@ -0,0 +1,44 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	c, err := NewClientFromFiles("../../../test/location.json", "../../../test/location_valves.json")
+	if err != nil {
+		t.Fatalf("Unable to create client, got err:\n%v", err)
+	}
+	ctx := context.Background()
+
+	locations, err := c.GetLocationsWithContext(ctx)
+	if err != nil || len(locations.Data) != 2 || locations.Data[1].Id != "location-2-id" || locations.Data[1].Attributes.Name != "Backyard" {
+		t.Fatalf("Unexpected locations %+v, got err %v", locations, err)
+	}
+	s, err := c.GetInitialStateForWithContext(ctx, locations.Data[0].Location)
+	if err != nil || len(s.Included) == 0 {
+		t.Fatalf("Unexpected state %+v, got err %v", s, err)
+	}
+	if _, err := c.GetInitialStateForWithContext(ctx, gardena.Location{Id: "unknown"}); !errors.Is(err, gardena.ErrNotFound) {
+		t.Fatalf("Expected %v, got %v", gardena.ErrNotFound, err)
+	}
+
+	if _, err := c.SendCommandWithContext(ctx, "dev-2-id", gardena.MowerControl{Command: "FLY"}); err == nil {
+		t.Fatalf("Expected invalid command to be rejected")
+	}
+	if _, err := c.SendCommandWithContext(ctx, "dev-2-id", gardena.MowerControl{Command: gardena.MowerParkUntilNextTask}); err != nil {
+		t.Fatalf("Unable to send command, got err:\n%v", err)
+	}
+	if sent := c.Commands(); len(sent) != 1 || sent[0].ServiceId != "dev-2-id" {
+		t.Fatalf("Expected the valid command to be recorded, got %+v", sent)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.GetLocationsWithContext(canceled); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected %v, got %v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,89 @@
+package gardena
+
+import (
+	"context"
+	"errors"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena/gardenatest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAPIAgainstFakeCloud(t *testing.T) {
+	cloud := gardenatest.NewServer()
+	defer cloud.Close()
+	cloud.SetCredentials("<some-client-id>", "<some-client-secret>")
+	if err := cloud.LoadFixture("../../test/location_valves.json"); err != nil {
+		t.Fatalf("Unable to load fixture, got err:\n%v", err)
+	}
+	secretFilePath := setupSecretFilesWithTmpDir("<some-client-id>", "<some-client-secret>")
+	defer os.RemoveAll(secretFilePath)
+
+	api, err := NewAPI().
+		WithBaseURL(cloud.APIURL()).
+		WithAuthURL(cloud.AuthURL()).
+		WithSecretFilePath(secretFilePath).
+		Initialize()
+	if err != nil {
+		t.Fatalf("Unable to initialize api, got err:\n%v", err)
+	}
+
+	locations, err := api.GetLocations()
+	if err != nil || len(locations.Data) != 1 || locations.Data[0].Attributes.Name != "Backyard" {
+		t.Fatalf("Unexpected locations %+v, got err %v", locations, err)
+	}
+	location := locations.Data[0].Location
+
+	// updates of commands are pushed to the websocket
+	rt := api.NewRealtime(location)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	connected := make(chan struct{})
+	var once sync.Once
+	rt.OnConnectionChange(func(c bool) {
+		if c {
+			once.Do(func() { close(connected) })
+		}
+	})
+	var updates []Device
+	done := make(chan error)
+	go func() {
+		done <- rt.Run(ctx, func(d Device) {
+			updates = append(updates, d)
+			if len(updates) == 2 {
+				cancel()
+			}
+		})
+	}()
+	<-connected
+	if _, err := api.SendCommand("dev-3-id:2", ValveControl{Command: ValveStartSecondsToOverride, Seconds: 600}); err != nil {
+		t.Fatalf("Unable to send command, got err:\n%v", err)
+	}
+	<-done
+	if len(updates) != 2 || updates[1].Id != "dev-3-id:2" || updates[1].Attributes["activity"].Value != "MANUAL_WATERING" ||
+		updates[1].Attributes["duration"].Value != float64(600) {
+		t.Fatalf("Unexpected updates %+v", updates)
+	}
+
+	s, err := api.GetInitialStateFor(location)
+	if err != nil {
+		t.Fatalf("Unable to get state, got err:\n%v", err)
+	}
+	for _, d := range s.Included {
+		if d.Id == "dev-3-id:2" && d.Type == "VALVE" && d.Attributes["activity"].Value != "MANUAL_WATERING" {
+			t.Fatalf("Expected valve to be watering, got %+v", d)
+		}
+	}
+
+	// revoked tokens are replaced
+	cloud.RevokeTokens()
+	if _, err := api.GetLocations(); err != nil || cloud.TokensIssued() != 2 {
+		t.Fatalf("Expected a new token to be requested, issued %d tokens, got err %v", cloud.TokensIssued(), err)
+	}
+
+	cloud.FailNext("/locations", 503)
+	if _, err := api.GetLocations(); !errors.Is(err, ErrServerError) {
+		t.Fatalf("Expected %v, got %v", ErrServerError, err)
+	}
+}
@@ -0,0 +1,530 @@
+// Package gardenatest provides a fake Gardena cloud for tests and local development. The Server
+// emulates the OAuth token endpoint of the Husqvarna authentication api as well as the locations,
+// command and websocket endpoints of the Gardena smart system api. Its locations are loaded from
+// fixture files like test/location.json and can be changed while it runs, changes are pushed to
+// connected websockets.
+//
+// The package doesn't depend on the gardena package, so it can be used by its tests as well.
+package gardenatest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TokenPath is the path of the OAuth token endpoint
+	TokenPath = "/oauth2/token"
+	// APIPath is the path of the Gardena api, the base url of an api client is URL + APIPath
+	APIPath = "/v1"
+
+	websocketPath = "/realtime/"
+	tokenPrefix   = "fake-token-"
+)
+
+// Command is a command received by the Server
+type Command struct {
+	ServiceId  string
+	Type       string
+	Attributes map[string]any
+}
+
+// CommandHandler handles a command received by the Server. An error rejects the command with
+// 400 Bad Request, the message of the error is returned as JSON:API error.
+type CommandHandler func(s *Server, c Command) error
+
+// Server is a fake Gardena cloud serving the locations of the loaded fixtures
+type Server struct {
+	// URL is the base url of the server, e.g. http://127.0.0.1:1234
+	URL string
+
+	srv      *httptest.Server
+	upgrader websocket.Upgrader
+
+	mu           sync.Mutex
+	clientID     string
+	clientSecret string
+	tokenTTL     time.Duration
+	issued       int
+	revoked      map[string]bool
+	locations    []*location
+	commands     []Command
+	onCommand    CommandHandler
+	failures     []failure
+	conns        map[string][]*websocket.Conn
+}
+
+// location is a fixture, kept as JSON document so it's served as it was loaded
+type location struct {
+	id   string
+	name string
+	doc  map[string]any
+}
+
+type failure struct {
+	path   string
+	status int
+}
+
+// NewServer starts a Server without locations. Its commands are applied with ApplyCommand.
+// The Server must be closed with Close.
+func NewServer() *Server {
+	s := newServer()
+	s.srv = httptest.NewServer(s.handler())
+	s.URL = s.srv.URL
+	return s
+}
+
+// NewServerAt starts a Server listening on the given address, e.g. to run a fake cloud on a fixed port
+func NewServerAt(addr string) (*Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on %s, got err:\n%w", addr, err)
+	}
+	s := newServer()
+	s.srv = httptest.NewUnstartedServer(s.handler())
+	s.srv.Listener.Close()
+	s.srv.Listener = l
+	s.srv.Start()
+	s.URL = s.srv.URL
+	return s, nil
+}
+
+func newServer() *Server {
+	return &Server{
+		tokenTTL:  24 * time.Hour,
+		revoked:   map[string]bool{},
+		onCommand: ApplyCommand,
+		conns:     map[string][]*websocket.Conn{},
+	}
+}
+
+// Close closes all websockets and shuts down the Server
+func (s *Server) Close() {
+	s.mu.Lock()
+	for _, conns := range s.conns {
+		for _, c := range conns {
+			c.Close()
+		}
+	}
+	s.conns = map[string][]*websocket.Conn{}
+	s.mu.Unlock()
+	s.srv.Close()
+}
+
+// APIURL returns the base url of the Gardena api of the Server
+func (s *Server) APIURL() string {
+	return s.URL + APIPath
+}
+
+// AuthURL returns the url of the OAuth token endpoint of the Server
+func (s *Server) AuthURL() string {
+	return s.URL + TokenPath
+}
+
+// SetCredentials sets the client id and secret the token endpoint accepts. Without credentials,
+// any non-empty client id and secret are accepted.
+func (s *Server) SetCredentials(clientID, clientSecret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clientID, s.clientSecret = clientID, clientSecret
+}
+
+// SetTokenTTL sets the lifetime of issued access tokens
+func (s *Server) SetTokenTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokenTTL = ttl
+}
+
+// RevokeTokens rejects all access tokens issued so far with 401 Unauthorized
+func (s *Server) RevokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 1; i <= s.issued; i++ {
+		s.revoked[fmt.Sprintf("%s%d", tokenPrefix, i)] = true
+	}
+}
+
+// TokensIssued returns the number of access tokens issued by the token endpoint
+func (s *Server) TokensIssued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issued
+}
+
+// OnCommand sets the handler of received commands, replacing ApplyCommand
+func (s *Server) OnCommand(h CommandHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onCommand = h
+}
+
+// Commands returns the commands received by the Server
+func (s *Server) Commands() []Command {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Command{}, s.commands...)
+}
+
+// FailNext answers the next request to the given path of the api, e.g. /locations, with the
+// given status code. Failures for the same path are used in the order they were added.
+func (s *Server) FailNext(path string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, failure{path: path, status: status})
+}
+
+// LoadFixture adds the location of the given fixture file, e.g. test/location.json
+func (s *Server) LoadFixture(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read fixture %s, got err:\n%w", path, err)
+	}
+	if err := s.AddLocation(b); err != nil {
+		return fmt.Errorf("unable to load fixture %s, got err:\n%w", path, err)
+	}
+	return nil
+}
+
+// AddLocation adds the location of the given JSON:API document, as returned by /locations/{id}.
+// A location with the same id is replaced.
+func (s *Server) AddLocation(doc []byte) error {
+	var d map[string]any
+	if err := json.Unmarshal(doc, &d); err != nil {
+		return fmt.Errorf("unable to unmarshal location, got err:\n%w", err)
+	}
+	data, _ := d["data"].(map[string]any)
+	id, _ := data["id"].(string)
+	if id == "" {
+		return fmt.Errorf("location document contains no id")
+	}
+	attrs, _ := data["attributes"].(map[string]any)
+	name, _ := attrs["name"].(string)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := &location{id: id, name: name, doc: d}
+	for i := range s.locations {
+		if s.locations[i].id == id {
+			s.locations[i] = l
+			return nil
+		}
+	}
+	s.locations = append(s.locations, l)
+	return nil
+}
+
+// RemoveLocation removes the location with the given id
+func (s *Server) RemoveLocation(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.locations {
+		if s.locations[i].id == id {
+			s.locations = append(s.locations[:i], s.locations[i+1:]...)
+			return
+		}
+	}
+}
+
+// SetAttribute sets the value of an attribute of the service with the given id and type, e.g.
+// the activity of a MOWER. The timestamp of the attribute is set to now and the changed service
+// is pushed to the websockets of its location.
+func (s *Server) SetAttribute(serviceId, serviceType, name string, value any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.setAttribute(serviceId, serviceType, name, value)
+}
+
+// setAttribute sets an attribute like SetAttribute. The caller must hold mu.
+func (s *Server) setAttribute(serviceId, serviceType, name string, value any) error {
+	l, service := s.findService(serviceId, serviceType)
+	if service == nil {
+		return fmt.Errorf("service %s of type %s not found", serviceId, serviceType)
+	}
+	attrs, ok := service["attributes"].(map[string]any)
+	if !ok {
+		attrs = map[string]any{}
+		service["attributes"] = attrs
+	}
+	attrs[name] = map[string]any{
+		"value":     value,
+		"timestamp": time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	s.push(l.id, service)
+	return nil
+}
+
+// findService returns the service with the given id and type and its location. The caller must hold mu.
+func (s *Server) findService(serviceId, serviceType string) (*location, map[string]any) {
+	for _, l := range s.locations {
+		included, _ := l.doc["included"].([]any)
+		for _, i := range included {
+			service, _ := i.(map[string]any)
+			if service["id"] == serviceId && service["type"] == serviceType {
+				return l, service
+			}
+		}
+	}
+	return nil, nil
+}
+
+// push sends the given service to all websockets of the location. The caller must hold mu.
+func (s *Server) push(locationId string, service map[string]any) {
+	msg, err := json.Marshal(service)
+	if err != nil {
+		return
+	}
+	conns := s.conns[locationId][:0]
+	for _, c := range s.conns[locationId] {
+		c.SetWriteDeadline(time.Now().Add(time.Second))
+		if err := c.WriteMessage(websocket.TextMessage, msg); err != nil {
+			c.Close()
+			continue
+		}
+		conns = append(conns, c)
+	}
+	s.conns[locationId] = conns
+}
+
+// ApplyCommand is the default CommandHandler. It changes the state of the commanded service like
+// the Gardena cloud would, e.g. a valve started for 600 seconds reports the activity MANUAL_WATERING
+// and a duration of 600.
+func ApplyCommand(s *Server, c Command) error {
+	command, _ := c.Attributes["command"].(string)
+	seconds, hasSeconds := c.Attributes["seconds"]
+	serviceType, activity := "", ""
+	switch c.Type {
+	case "MOWER_CONTROL":
+		serviceType = "MOWER"
+		activity = map[string]string{
+			"START_SECONDS_TO_OVERRIDE": "OK_CUTTING_TIMER_OVERRIDDEN",
+			"START_DONT_OVERRIDE":       "OK_CUTTING",
+			"PARK_UNTIL_NEXT_TASK":      "PARKED_TIMER",
+			"PARK_UNTIL_FURTHER_NOTICE": "PARKED_PARK_SELECTED",
+		}[command]
+	case "VALVE_CONTROL":
+		serviceType = "VALVE"
+		activity = map[string]string{
+			"START_SECONDS_TO_OVERRIDE": "MANUAL_WATERING",
+			"STOP_UNTIL_NEXT_TASK":      "CLOSED",
+		}[command]
+	case "POWER_SOCKET_CONTROL":
+		serviceType = "POWER_SOCKET"
+		activity = map[string]string{
+			"START_SECONDS_TO_OVERRIDE": "TIME_LIMITED_ON",
+			"START_OVERRIDE":            "FOREVER_ON",
+			"STOP_UNTIL_NEXT_TASK":      "OFF",
+		}[command]
+	default:
+		return fmt.Errorf("unsupported command type %s", c.Type)
+	}
+	if activity == "" {
+		// e.g. PAUSE, which only affects the schedule
+		return nil
+	}
+	if err := s.SetAttribute(c.ServiceId, serviceType, "activity", activity); err != nil {
+		return err
+	}
+	if hasSeconds {
+		return s.SetAttribute(c.ServiceId, serviceType, "duration", seconds)
+	}
+	return nil
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(TokenPath, s.handleToken)
+	mux.Handle(APIPath+"/", http.StripPrefix(APIPath, s.authenticated(http.HandlerFunc(s.handleAPI))))
+	mux.HandleFunc(APIPath+"/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc(websocketPath, s.handleWebsocket)
+	return mux
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", r.Method)
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "client_credentials" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "expected grant_type client_credentials")
+		return
+	}
+	id, secret := r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id == "" || secret == "" || s.clientID != "" && (id != s.clientID || secret != s.clientSecret) {
+		writeError(w, http.StatusUnauthorized, "invalid_client", "client id or secret is invalid")
+		return
+	}
+	s.issued++
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": fmt.Sprintf("%s%d", tokenPrefix, s.issued),
+		"token_type":   "Bearer",
+		"expires_in":   int(s.tokenTTL.Seconds()),
+		"provider":     "husqvarna",
+		"user_id":      "fake-user",
+		"scope":        "iam:read amc:api",
+	})
+}
+
+// authenticated rejects requests without a valid access token or api key
+func (s *Server) authenticated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		s.mu.Lock()
+		valid := strings.HasPrefix(token, tokenPrefix) && !s.revoked[token] && r.Header.Get("X-Api-Key") != ""
+		s.mu.Unlock()
+		if !valid {
+			writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "access token or api key is invalid")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, f := range s.failures {
+		if f.path == r.URL.Path {
+			s.failures = append(s.failures[:i], s.failures[i+1:]...)
+			writeError(w, f.status, "FAKE_FAILURE", fmt.Sprintf("failure of %s requested by the test", f.path))
+			return
+		}
+	}
+
+	switch path := r.URL.Path; {
+	case path == "/locations" && r.Method == http.MethodGet:
+		data := make([]any, 0, len(s.locations))
+		for _, l := range s.locations {
+			data = append(data, map[string]any{"id": l.id, "type": "LOCATION", "attributes": map[string]any{"name": l.name}})
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"data": data})
+	case strings.HasPrefix(path, "/locations/") && r.Method == http.MethodGet:
+		id := strings.TrimPrefix(path, "/locations/")
+		for _, l := range s.locations {
+			if l.id == id {
+				writeJSON(w, http.StatusOK, l.doc)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "LOCATION_NOT_FOUND", fmt.Sprintf("location %s not found", id))
+	case strings.HasPrefix(path, "/command/") && r.Method == http.MethodPut:
+		s.handleCommand(w, r, strings.TrimPrefix(path, "/command/"))
+	case path == "/websocket" && r.Method == http.MethodPost:
+		s.handleWebsocketRequest(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("%s %s not found", r.Method, path))
+	}
+}
+
+// handleCommand handles a command. The caller must hold mu, it's released while the
+// CommandHandler runs.
+func (s *Server) handleCommand(w http.ResponseWriter, r *http.Request, serviceId string) {
+	var req struct {
+		Data struct {
+			Id         string         `json:"id"`
+			Type       string         `json:"type"`
+			Attributes map[string]any `json:"attributes"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", err.Error())
+		return
+	}
+	serviceType := strings.TrimSuffix(req.Data.Type, "_CONTROL")
+	if _, service := s.findService(serviceId, serviceType); service == nil {
+		writeError(w, http.StatusNotFound, "SERVICE_NOT_FOUND", fmt.Sprintf("service %s of type %s not found", serviceId, serviceType))
+		return
+	}
+	c := Command{ServiceId: serviceId, Type: req.Data.Type, Attributes: req.Data.Attributes}
+	s.commands = append(s.commands, c)
+
+	h := s.onCommand
+	s.mu.Unlock()
+	err := h(s, c)
+	s.mu.Lock()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_COMMAND", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleWebsocketRequest answers a request for a websocket url. The caller must hold mu.
+func (s *Server) handleWebsocketRequest(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Data struct {
+			Attributes struct {
+				LocationId string `json:"locationId"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", err.Error())
+		return
+	}
+	id := req.Data.Attributes.LocationId
+	found := false
+	for _, l := range s.locations {
+		found = found || l.id == id
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "LOCATION_NOT_FOUND", fmt.Sprintf("location %s not found", id))
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"data": map[string]any{
+		"id":   "websocket-" + id,
+		"type": "WEBSOCKET",
+		"attributes": map[string]any{
+			"validity": 60,
+			"url":      "ws" + strings.TrimPrefix(s.URL, "http") + websocketPath + id,
+		},
+	}})
+}
+
+func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, websocketPath)
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.conns[id] = append(s.conns[id], conn)
+	s.mu.Unlock()
+
+	// answers pings and notices closed connections, clients don't send messages
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			conn.Close()
+			return
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/vnd.api+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, code, detail string) {
+	writeJSON(w, status, map[string]any{"errors": []any{map[string]any{
+		"status": fmt.Sprint(status),
+		"code":   code,
+		"title":  http.StatusText(status),
+		"detail": detail,
+	}}})
+}
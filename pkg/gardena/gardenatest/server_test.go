@@ -0,0 +1,76 @@
+package gardenatest
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestServerRejectsInvalidCredentials(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.SetCredentials("id", "secret")
+
+	res, err := http.PostForm(s.AuthURL(), url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {"id"},
+		"client_secret": {"guess"},
+	})
+	if err != nil {
+		t.Fatalf("Unable to request token, got err:\n%v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized || s.TokensIssued() != 0 {
+		t.Fatalf("Expected invalid credentials to be rejected, got status %d", res.StatusCode)
+	}
+
+	res, err = http.Get(s.APIURL() + "/locations")
+	if err != nil {
+		t.Fatalf("Unable to query locations, got err:\n%v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected request without token to be rejected, got status %d", res.StatusCode)
+	}
+
+	res, err = http.Get(s.APIURL() + "/health")
+	if err != nil {
+		t.Fatalf("Unable to query health, got err:\n%v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected health endpoint to be public, got status %d", res.StatusCode)
+	}
+}
+
+func TestServerFixtures(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	if err := s.LoadFixture("../../../test/location.json"); err != nil {
+		t.Fatalf("Unable to load fixture, got err:\n%v", err)
+	}
+	if err := s.AddLocation([]byte(`{"data": {"type": "LOCATION"}}`)); err == nil {
+		t.Fatalf("Expected location without id to be rejected")
+	}
+
+	if err := s.SetAttribute("dev-2-id", "MOWER", "activity", "OK_CUTTING"); err != nil {
+		t.Fatalf("Unable to set attribute, got err:\n%v", err)
+	}
+	if err := s.SetAttribute("dev-2-id", "SENSOR", "activity", "OK_CUTTING"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("Expected unknown service to be rejected, got %v", err)
+	}
+	if err := ApplyCommand(s, Command{ServiceId: "dev-2-id", Type: "MOWER_CONTROL", Attributes: map[string]any{"command": "PARK_UNTIL_FURTHER_NOTICE"}}); err != nil {
+		t.Fatalf("Unable to apply command, got err:\n%v", err)
+	}
+	_, mower := s.findService("dev-2-id", "MOWER")
+	activity := mower["attributes"].(map[string]any)["activity"].(map[string]any)
+	if activity["value"] != "PARKED_PARK_SELECTED" {
+		t.Fatalf("Expected mower to be parked, got %v", activity)
+	}
+
+	s.RemoveLocation("location-1-id")
+	if err := s.SetAttribute("dev-2-id", "MOWER", "activity", "OK_CUTTING"); err == nil {
+		t.Fatalf("Expected services of removed location to be gone")
+	}
+}
@@ -0,0 +1,225 @@
+package gardena
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMinThrottleBackoff = time.Second
+	defaultMaxThrottleBackoff = 5 * time.Minute
+	maxThrottleRetries        = 3
+)
+
+// ErrBudgetExhausted is returned instead of sending a request if a configured request budget is used up
+var ErrBudgetExhausted = errors.New("request budget exhausted")
+
+// RateLimitStatus describes the state of the client side rate limiting of an API
+type RateLimitStatus struct {
+	Budgets []BudgetStatus
+	// Throttled is the number of 429 Too Many Requests responses received
+	Throttled int
+	// Rejected is the number of requests not sent because a budget was exhausted
+	Rejected int
+	// BlockedUntil is the time requests are held back after a 429 response
+	BlockedUntil time.Time
+}
+
+// BudgetStatus describes a single request budget
+type BudgetStatus struct {
+	Limit     int
+	Period    time.Duration
+	Remaining int
+	// ResetsAt is the end of the current window, zero if no request was sent within the window
+	ResetsAt time.Time
+}
+
+// budget counts the requests of a fixed window, starting with the first request of the window
+type budget struct {
+	limit  int
+	period time.Duration
+	start  time.Time
+	used   int
+}
+
+func (b *budget) reset(now time.Time) {
+	if b.start.IsZero() || !now.Before(b.start.Add(b.period)) {
+		b.start = now
+		b.used = 0
+	}
+}
+
+// limiter holds back requests after the api answered with 429 Too Many Requests and keeps
+// requests within the configured budgets. A nil limiter doesn't limit at all.
+type limiter struct {
+	mu      sync.Mutex
+	budgets []*budget
+
+	blockedUntil time.Time
+	backoff      time.Duration
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+
+	throttled int
+	rejected  int
+
+	now func() time.Time
+}
+
+func newLimiter() *limiter {
+	return &limiter{
+		minBackoff: defaultMinThrottleBackoff,
+		maxBackoff: defaultMaxThrottleBackoff,
+		now:        time.Now,
+	}
+}
+
+func (l *limiter) addBudget(limit int, period time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.budgets = append(l.budgets, &budget{limit: limit, period: period})
+}
+
+// acquire waits until the api accepts requests again and takes a request from every budget.
+// If a budget is exhausted, no request is taken from any budget. A 429 response received while
+// waiting extends the wait.
+func (l *limiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	for {
+		l.mu.Lock()
+		wait, maxBackoff := l.blockedUntil.Sub(l.now()), l.maxBackoff
+		if wait <= 0 {
+			defer l.mu.Unlock()
+			return l.take()
+		}
+		l.mu.Unlock()
+		if wait > maxBackoff {
+			return fmt.Errorf("%w, api asked to wait %v", ErrRateLimited, wait.Round(time.Second))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// take takes a request from every budget, unless one of them is exhausted. The caller must hold mu.
+func (l *limiter) take() error {
+	now := l.now()
+	for _, b := range l.budgets {
+		b.reset(now)
+		if b.used >= b.limit {
+			l.rejected++
+			return fmt.Errorf("%w, used %d requests of %d per %v, resets at %v",
+				ErrBudgetExhausted, b.used, b.limit, b.period, b.start.Add(b.period).Format(time.RFC3339))
+		}
+	}
+	for _, b := range l.budgets {
+		b.used++
+	}
+	return nil
+}
+
+// throttle blocks requests after a 429 response, for the duration of the Retry-After header if
+// given, otherwise with an exponential backoff
+func (l *limiter) throttle(retryAfter string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.throttled++
+	now := l.now()
+	wait, ok := parseRetryAfter(retryAfter, now)
+	if !ok {
+		if l.backoff == 0 {
+			l.backoff = l.minBackoff
+		} else {
+			l.backoff = minDuration(2*l.backoff, l.maxBackoff)
+		}
+		wait = jitter(l.backoff)
+	}
+	if until := now.Add(wait); until.After(l.blockedUntil) {
+		l.blockedUntil = until
+	}
+}
+
+// succeeded resets the backoff once the api accepts requests again
+func (l *limiter) succeeded() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.backoff = 0
+}
+
+func (l *limiter) status() RateLimitStatus {
+	if l == nil {
+		return RateLimitStatus{}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	s := RateLimitStatus{Throttled: l.throttled, Rejected: l.rejected, BlockedUntil: l.blockedUntil}
+	for _, b := range l.budgets {
+		bs := BudgetStatus{Limit: b.limit, Period: b.period, Remaining: b.limit}
+		// the window of a budget starts with its first request
+		if !b.start.IsZero() && now.Before(b.start.Add(b.period)) {
+			bs.Remaining = b.limit - b.used
+			bs.ResetsAt = b.start.Add(b.period)
+		}
+		s.Budgets = append(s.Budgets, bs)
+	}
+	return s
+}
+
+// RateLimitStatus returns the current state of the client side rate limiting
+func (api *API) RateLimitStatus() RateLimitStatus {
+	return api.limiter.status()
+}
+
+// parseRetryAfter parses the value of a Retry-After header, either as seconds or as http date
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(v); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return maxDuration(t.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// jitter returns a random duration between d/2 and d
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,131 @@
+package gardena
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestRetriesAfterTooManyRequests(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"data": []}`))
+	}))
+	defer server.Close()
+
+	api := API{baseURL: server.URL, httpClient: &http.Client{}, limiter: newLimiter()}
+	if _, err := api.GetLocations(); err != nil {
+		t.Fatalf("Expected request to be retried, got err:\n%v", err)
+	}
+	if s := api.RateLimitStatus(); calls != 2 || s.Throttled != 1 {
+		t.Fatalf("Expected one throttled and one successful call, got %d calls and status %+v", calls, s)
+	}
+}
+
+func TestRequestGivesUpWhenRateLimited(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	l := newLimiter()
+	l.minBackoff = time.Millisecond
+	api := API{baseURL: server.URL, httpClient: &http.Client{}, limiter: l}
+	if _, err := api.GetLocations(); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Expected %v, got %v", ErrRateLimited, err)
+	}
+	if calls != maxThrottleRetries+1 {
+		t.Fatalf("Expected %d calls, got %d", maxThrottleRetries+1, calls)
+	}
+
+	// requests aren't sent at all while the api asks to wait longer than the max backoff
+	l.throttle("3600")
+	if _, err := api.GetLocations(); !errors.Is(err, ErrRateLimited) || calls != maxThrottleRetries+1 {
+		t.Fatalf("Expected request to be held back, got %d calls and err %v", calls, err)
+	}
+}
+
+func TestAcquireWaitsForThrottleWhileWaiting(t *testing.T) {
+	l := newLimiter()
+	l.blockedUntil = time.Now().Add(20 * time.Millisecond)
+	start := time.Now()
+	done := make(chan error)
+	go func() { done <- l.acquire(context.Background()) }()
+
+	// a 429 response of another request while acquire is waiting
+	time.Sleep(5 * time.Millisecond)
+	l.throttle("1")
+	if err := <-done; err != nil {
+		t.Fatalf("Unable to acquire request, got err:\n%v", err)
+	}
+	if waited := time.Since(start); waited < time.Second {
+		t.Fatalf("Expected acquire to wait for the second throttle, waited %v", waited)
+	}
+}
+
+func TestRequestBudget(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": []}`))
+	}))
+	defer server.Close()
+
+	now := time.Date(2023, 6, 8, 12, 0, 0, 0, time.UTC)
+	l := newLimiter()
+	l.now = func() time.Time { return now }
+	l.addBudget(2, time.Hour)
+	l.addBudget(10, 24*time.Hour)
+	api := API{baseURL: server.URL, httpClient: &http.Client{}, limiter: l}
+
+	for i := 0; i < 2; i++ {
+		if _, err := api.GetLocations(); err != nil {
+			t.Fatalf("Expected request within budget, got err:\n%v", err)
+		}
+	}
+	if _, err := api.GetLocations(); !errors.Is(err, ErrBudgetExhausted) {
+		t.Fatalf("Expected %v, got %v", ErrBudgetExhausted, err)
+	}
+	s := api.RateLimitStatus()
+	if s.Rejected != 1 || s.Budgets[0].Remaining != 0 || s.Budgets[1].Remaining != 8 || !s.Budgets[0].ResetsAt.Equal(now.Add(time.Hour)) {
+		t.Fatalf("Unexpected status %+v", s)
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := api.GetLocations(); err != nil {
+		t.Fatalf("Expected budget to be reset, got err:\n%v", err)
+	}
+	if s := api.RateLimitStatus(); s.Budgets[0].Remaining != 1 || s.Budgets[1].Remaining != 7 {
+		t.Fatalf("Unexpected status %+v", s)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2023, 6, 8, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{"Thu, 08 Jun 2023 12:00:30 GMT", 30 * time.Second, true},
+		{"Thu, 08 Jun 2023 11:00:00 GMT", 0, true},
+		{"", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		d, ok := parseRetryAfter(tt.value, now)
+		if d != tt.expected || ok != tt.ok {
+			t.Fatalf("Expected %v/%v for '%s', got %v/%v", tt.expected, tt.ok, tt.value, d, ok)
+		}
+	}
+}
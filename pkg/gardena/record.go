@@ -0,0 +1,298 @@
+package gardena
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// redacted replaces credentials and serials in recordings
+const redacted = "REDACTED"
+
+// redactedFormKeys are the form values of requests that are redacted, e.g. the client credentials
+var redactedFormKeys = []string{"client_id", "client_secret"}
+
+// redactedJSONKeys are the keys of JSON bodies whose values are redacted. If the value is an
+// attribute object, only its value is redacted.
+var redactedJSONKeys = map[string]bool{
+	"access_token":  true,
+	"refresh_token": true,
+	"user_id":       true,
+	"serial":        true,
+	"url":           true,
+}
+
+// recordedHeaders are the response headers kept in recordings
+var recordedHeaders = []string{"Content-Type", "Retry-After"}
+
+// Interaction is a recorded request and its response. Recordings are stored as one
+// JSON encoded Interaction per line.
+type Interaction struct {
+	Method      string            `json:"method"`
+	URL         string            `json:"url"`
+	RequestBody string            `json:"requestBody,omitempty"`
+	StatusCode  int               `json:"statusCode"`
+	Header      map[string]string `json:"header,omitempty"`
+	Body        string            `json:"body"`
+}
+
+// recordingTransport is a http.RoundTripper writing every request and its response to a recording,
+// with credentials and serials redacted
+type recordingTransport struct {
+	next http.RoundTripper
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newRecordingTransport(next http.RoundTripper, w io.Writer) *recordingTransport {
+	return &recordingTransport{next: next, w: w}
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read request body for recording, got err:\n%w", err)
+		}
+		reqBody = b
+		req.Body = io.NopCloser(bytes.NewReader(b))
+	}
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read response body for recording, got err:\n%w", err)
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+
+	i := Interaction{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		RequestBody: redactRequestBody(req.Header.Get("Content-Type"), reqBody),
+		StatusCode:  res.StatusCode,
+		Header:      map[string]string{},
+		Body:        redactBody(body),
+	}
+	for _, h := range recordedHeaders {
+		if v := res.Header.Get(h); v != "" {
+			i.Header[h] = v
+		}
+	}
+	line, err := json.Marshal(i)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal recording, got err:\n%w", err)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, err := t.w.Write(append(line, '\n')); err != nil {
+		return nil, fmt.Errorf("unable to write recording, got err:\n%w", err)
+	}
+	return res, nil
+}
+
+// replayTransport is a http.RoundTripper answering requests with the responses of a recording.
+// Requests are matched by method and url path, repeated requests get the recorded responses in
+// order. Once all responses of a request were replayed, the last one is repeated.
+type replayTransport struct {
+	mu           sync.Mutex
+	interactions map[string][]Interaction
+	replayed     map[string]int
+}
+
+// ReadRecording reads the interactions of a recording file
+func ReadRecording(path string) ([]Interaction, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open recording, got err:\n%w", err)
+	}
+	defer f.Close()
+	var interactions []Interaction
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var i Interaction
+		if err := json.Unmarshal(scanner.Bytes(), &i); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal line %d of recording %s, got err:\n%w", n, path, err)
+		}
+		interactions = append(interactions, i)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read recording %s, got err:\n%w", path, err)
+	}
+	return interactions, nil
+}
+
+func newReplayTransport(interactions []Interaction) (*replayTransport, error) {
+	t := &replayTransport{interactions: map[string][]Interaction{}, replayed: map[string]int{}}
+	for _, i := range interactions {
+		u, err := url.Parse(i.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid url %s in recording, got err:\n%w", i.URL, err)
+		}
+		key := replayKey(i.Method, u)
+		t.interactions[key] = append(t.interactions[key], i)
+	}
+	return t, nil
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	key := replayKey(req.Method, req.URL)
+	t.mu.Lock()
+	recorded := t.interactions[key]
+	n := t.replayed[key]
+	if n < len(recorded)-1 {
+		t.replayed[key] = n + 1
+	}
+	t.mu.Unlock()
+
+	res := &http.Response{
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Request:    req,
+	}
+	if len(recorded) == 0 {
+		res.StatusCode = http.StatusNotFound
+		res.Header.Set("Content-Type", jsonAPIContentType)
+		res.Body = io.NopCloser(strings.NewReader(`{"errors": [{"code": "NOT_RECORDED", "title": "No recorded response for ` + key + `"}]}`))
+	} else {
+		i := recorded[n]
+		res.StatusCode = i.StatusCode
+		for k, v := range i.Header {
+			res.Header.Set(k, v)
+		}
+		res.Body = io.NopCloser(strings.NewReader(i.Body))
+	}
+	res.Status = fmt.Sprintf("%d %s", res.StatusCode, http.StatusText(res.StatusCode))
+	return res, nil
+}
+
+func replayKey(method string, u *url.URL) string {
+	return method + " " + u.Path
+}
+
+// redactRequestBody redacts the credentials of a form encoded request body
+func redactRequestBody(contentType string, body []byte) string {
+	if !strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		return redactBody(body)
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return redacted
+	}
+	for _, k := range redactedFormKeys {
+		if form.Has(k) {
+			form.Set(k, redacted)
+		}
+	}
+	return form.Encode()
+}
+
+// redactBody redacts credentials and serials of a JSON body. Their values are replaced in the raw
+// body, so the order and duplicates of keys and the formatting of numbers are kept as received.
+// Bodies that aren't JSON are kept as they are.
+func redactBody(body []byte) string {
+	r := jsonRedactor{body: body, dec: json.NewDecoder(bytes.NewReader(body))}
+	if err := r.value(false); err != nil {
+		return string(body)
+	}
+	if _, err := r.dec.Token(); !errors.Is(err, io.EOF) {
+		return string(body)
+	}
+	var b strings.Builder
+	last := 0
+	for _, s := range r.spans {
+		b.Write(body[last:s.start])
+		b.WriteString(`"` + redacted + `"`)
+		last = s.end
+	}
+	b.Write(body[last:])
+	return b.String()
+}
+
+// span is the position of a redacted value in a body
+type span struct {
+	start, end int
+}
+
+// jsonRedactor finds the spans of the values to redact in a JSON body
+type jsonRedactor struct {
+	body  []byte
+	dec   *json.Decoder
+	spans []span
+}
+
+// value reads the next value of the body. If it's the value of a redacted key, it's redacted as a
+// whole, unless it's an attribute object, of which only the value is redacted.
+func (r *jsonRedactor) value(redact bool) error {
+	start := r.valueStart()
+	n := len(r.spans)
+	tok, err := r.dec.Token()
+	if err != nil {
+		return err
+	}
+	attribute := false
+	switch tok {
+	case json.Delim('{'):
+		for r.dec.More() {
+			key, err := r.dec.Token()
+			if err != nil {
+				return err
+			}
+			k, _ := key.(string)
+			if redact && k == "value" {
+				attribute = true
+			}
+			if err := r.value(redactedJSONKeys[k] || redact && k == "value"); err != nil {
+				return err
+			}
+		}
+		if _, err := r.dec.Token(); err != nil {
+			return err
+		}
+	case json.Delim('['):
+		for r.dec.More() {
+			if err := r.value(false); err != nil {
+				return err
+			}
+		}
+		if _, err := r.dec.Token(); err != nil {
+			return err
+		}
+	}
+	if redact && !attribute {
+		r.spans = append(r.spans[:n], span{start: start, end: int(r.dec.InputOffset())})
+	}
+	return nil
+}
+
+// valueStart returns the position of the next value, the decoder is positioned after the
+// previous token, which may be followed by whitespace, a colon or a comma
+func (r *jsonRedactor) valueStart() int {
+	i := int(r.dec.InputOffset())
+	for i < len(r.body) && strings.ContainsRune(" \t\r\n:,", rune(r.body[i])) {
+		i++
+	}
+	return i
+}
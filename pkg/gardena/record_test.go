@@ -0,0 +1,104 @@
+package gardena
+
+import (
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena/gardenatest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	cloud := gardenatest.NewServer()
+	if err := cloud.LoadFixture("../../test/location.json"); err != nil {
+		t.Fatalf("Unable to load fixture, got err:\n%v", err)
+	}
+	secretFilePath := setupSecretFilesWithTmpDir("<some-client-id>", "<some-client-secret>")
+	defer os.RemoveAll(secretFilePath)
+	recording := filepath.Join(t.TempDir(), "recording.jsonl")
+
+	api, err := NewAPI().
+		WithBaseURL(cloud.APIURL()).
+		WithAuthURL(cloud.AuthURL()).
+		WithSecretFilePath(secretFilePath).
+		WithRecording(recording).
+		Initialize()
+	if err != nil {
+		t.Fatalf("Unable to initialize api, got err:\n%v", err)
+	}
+	locations, err := api.GetLocations()
+	if err != nil {
+		t.Fatalf("Unable to get locations, got err:\n%v", err)
+	}
+	state, err := api.GetInitialStateFor(locations.Data[0].Location)
+	if err != nil {
+		t.Fatalf("Unable to get state, got err:\n%v", err)
+	}
+	if err := api.Close(); err != nil {
+		t.Fatalf("Unable to close recording, got err:\n%v", err)
+	}
+	if _, err := api.GetLocations(); err == nil || !strings.Contains(err.Error(), "recording") {
+		t.Fatalf("Expected requests after closing the recording to fail, got err %v", err)
+	}
+	cloud.Close()
+
+	b, err := os.ReadFile(recording)
+	if err != nil {
+		t.Fatalf("Unable to read recording, got err:\n%v", err)
+	}
+	for _, secret := range []string{"<some-client-id>", "<some-client-secret>", "fake-token-1", "fake-user", "123456", "54321"} {
+		if strings.Contains(string(b), secret) {
+			t.Fatalf("Expected %s to be redacted from recording:\n%s", secret, b)
+		}
+	}
+
+	// replays without secret files and network access, the urls are only used to match the recorded requests
+	replay, err := NewAPI().
+		WithBaseURL(cloud.APIURL()).
+		WithAuthURL(cloud.AuthURL()).
+		WithReplay(recording).
+		Initialize()
+	if err != nil {
+		t.Fatalf("Unable to initialize replaying api, got err:\n%v", err)
+	}
+	replayedLocations, err := replay.GetLocations()
+	if err != nil || !reflect.DeepEqual(replayedLocations, locations) {
+		t.Fatalf("Expected replayed locations %+v, got %+v and err %v", locations, replayedLocations, err)
+	}
+	// responses are repeated
+	for i := 0; i < 2; i++ {
+		replayedState, err := replay.GetInitialStateFor(locations.Data[0].Location)
+		if err != nil || len(replayedState.Included) != len(state.Included) {
+			t.Fatalf("Expected replayed state, got %+v and err %v", replayedState, err)
+		}
+	}
+	if _, err := replay.GetInitialStateFor(Location{Id: "unknown"}); err == nil {
+		t.Fatalf("Expected request without recording to fail")
+	}
+}
+
+func TestRecordAndReplayCombined(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	if _, err := NewAPI().WithRecording(path).WithReplay(path).Initialize(); err == nil {
+		t.Fatalf("Expected recording and replaying to be rejected")
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	// only the redacted values are replaced, key order, duplicate keys and numbers are kept
+	body := redactBody([]byte(`{"access_token": "abc", "data": [{"attributes": {"serial": {"value": "123", "timestamp": "2023-06-08T17:39:42.000+00:00"}, "name": {"value": "SILENO"}, "rfLinkLevel": {"value": 1.50e2}, "name": {"value": "SILENO 2"}, "url": ["wss://", {"serial": 1}]}}]}`))
+	expected := `{"access_token": "REDACTED", "data": [{"attributes": {"serial": {"value": "REDACTED", "timestamp": "2023-06-08T17:39:42.000+00:00"}, "name": {"value": "SILENO"}, "rfLinkLevel": {"value": 1.50e2}, "name": {"value": "SILENO 2"}, "url": "REDACTED"}}]}`
+	if body != expected {
+		t.Fatalf("Expected %s, got %s", expected, body)
+	}
+	for _, b := range []string{"", "Not Found", `{"access_token": "abc"`, `{"serial": 1} {"serial": 2}`} {
+		if body := redactBody([]byte(b)); body != b {
+			t.Fatalf("Expected body that isn't JSON to be kept, got %s", body)
+		}
+	}
+	form := redactRequestBody("application/x-www-form-urlencoded", []byte("client_id=abc&client_secret=def&grant_type=client_credentials"))
+	if form != "client_id=REDACTED&client_secret=REDACTED&grant_type=client_credentials" {
+		t.Fatalf("Expected credentials to be redacted, got %s", form)
+	}
+}
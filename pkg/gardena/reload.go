@@ -0,0 +1,103 @@
+package gardena
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// CredentialReloadStatus is the status of reloading rotated credentials of the API
+type CredentialReloadStatus struct {
+	// Succeeded is the number of reloads that switched the API to new credentials
+	Succeeded int
+	// Failed is the number of reloads that failed, the API kept using its current credentials
+	Failed int
+	// LastSucceeded reports if the last check of the credentials succeeded, i.e. they were read and
+	// were either unchanged or switched to. It's true if there was no check yet.
+	LastSucceeded bool
+	// LastSuccessAt is the time of the last successful reload, zero if there was none
+	LastSuccessAt time.Time
+}
+
+// ReloadCredentials reads the credentials of the configured credential provider again. If they
+// changed, e.g. because the secret was rotated, a new access token is requested with them. Only
+// once that succeeded, the API switches to the new credentials and token at once. Otherwise the
+// current credentials and token are kept. ReloadCredentials reports if the credentials changed.
+func (api *API) ReloadCredentials(ctx context.Context) (bool, error) {
+	if api.credentials == nil {
+		return false, fmt.Errorf("no credential provider configured")
+	}
+	c, err := api.credentials.Credentials(ctx)
+	if err != nil {
+		api.reloaded(err)
+		return false, fmt.Errorf("unable to read credentials from %s, got err:\n %w", api.credentials, err)
+	}
+
+	api.refreshMu.Lock()
+	defer api.refreshMu.Unlock()
+	if c.ClientID == api.clientID && c.ClientSecret == api.clientSecret {
+		api.reloadMu.Lock()
+		api.reloadStatus.LastSucceeded = true
+		api.reloadMu.Unlock()
+		return false, nil
+	}
+	auth, err := api.fetchToken(ctx, c.ClientID, c.ClientSecret)
+	if err != nil {
+		api.reloaded(err)
+		return true, fmt.Errorf("unable to authenticate with the changed credentials of %s, keeping the current ones, got err:\n %w", api.credentials, err)
+	}
+	api.tokenMu.Lock()
+	api.clientID, api.clientSecret = c.ClientID, c.ClientSecret
+	api.setToken(auth)
+	api.tokenMu.Unlock()
+	api.reloaded(nil)
+	return true, nil
+}
+
+// reloaded records the result of a reload
+func (api *API) reloaded(err error) {
+	api.reloadMu.Lock()
+	defer api.reloadMu.Unlock()
+	api.reloadStatus.LastSucceeded = err == nil
+	if err != nil {
+		api.reloadStatus.Failed++
+		return
+	}
+	api.reloadStatus.Succeeded++
+	api.reloadStatus.LastSuccessAt = time.Now()
+}
+
+// CredentialReloadStatus returns the status of reloading rotated credentials
+func (api *API) CredentialReloadStatus() CredentialReloadStatus {
+	api.reloadMu.Lock()
+	defer api.reloadMu.Unlock()
+	s := api.reloadStatus
+	if s.Failed == 0 {
+		s.LastSucceeded = true
+	}
+	return s
+}
+
+// RunCredentialReload reloads the credentials of the configured credential provider in the given
+// interval, see ReloadCredentials. RunCredentialReload blocks until the given context is canceled.
+func (api *API) RunCredentialReload(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		changed, err := api.ReloadCredentials(ctx)
+		switch {
+		case err != nil && ctx.Err() != nil:
+			return ctx.Err()
+		case err != nil:
+			log.Printf("Unable to reload credentials, got err:\n%v", err)
+		case changed:
+			log.Printf("Reloaded changed credentials from %s", api.credentials)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package gardena
+
+import (
+	"context"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena/gardenatest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadCredentials(t *testing.T) {
+	cloud := gardenatest.NewServer()
+	defer cloud.Close()
+	if err := cloud.LoadFixture("../../test/location.json"); err != nil {
+		t.Fatalf("Unable to load fixture, got err:\n%v", err)
+	}
+	cloud.SetCredentials("<some-client-id>", "<old-client-secret>")
+	secretFilePath := setupSecretFilesWithTmpDir("<some-client-id>", "<old-client-secret>")
+	defer os.RemoveAll(secretFilePath)
+
+	api, err := NewAPI().
+		WithBaseURL(cloud.APIURL()).
+		WithAuthURL(cloud.AuthURL()).
+		WithSecretFilePath(secretFilePath).
+		Initialize()
+	if err != nil {
+		t.Fatalf("Unable to initialize api, got err:\n%v", err)
+	}
+	ctx := context.Background()
+
+	if changed, err := api.ReloadCredentials(ctx); changed || err != nil {
+		t.Fatalf("Expected unchanged credentials, got changed %v and err %v", changed, err)
+	}
+	if s := api.CredentialReloadStatus(); !s.LastSucceeded || s.Succeeded != 0 || s.Failed != 0 {
+		t.Fatalf("Expected no reload, got %+v", s)
+	}
+
+	// the secret is rotated in the file before the api accepts it
+	if err := os.WriteFile(filepath.Join(secretFilePath, clientSecretFile), []byte("<new-client-secret>"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := api.ReloadCredentials(ctx); !changed || err == nil {
+		t.Fatalf("Expected reload of rejected credentials to fail, got changed %v and err %v", changed, err)
+	}
+	if api.clientSecret != "<old-client-secret>" || api.accessToken != "Bearer fake-token-1" {
+		t.Fatalf("Expected current credentials and token to be kept, got %s and %s", api.clientSecret, api.accessToken)
+	}
+	if _, err := api.GetLocations(); err != nil {
+		t.Fatalf("Expected current token to keep working, got err:\n%v", err)
+	}
+	if s := api.CredentialReloadStatus(); s.LastSucceeded || s.Failed != 1 {
+		t.Fatalf("Expected failed reload, got %+v", s)
+	}
+
+	cloud.SetCredentials("<some-client-id>", "<new-client-secret>")
+	if changed, err := api.ReloadCredentials(ctx); !changed || err != nil {
+		t.Fatalf("Expected reload to succeed, got changed %v and err %v", changed, err)
+	}
+	if api.clientSecret != "<new-client-secret>" || api.accessToken != "Bearer fake-token-2" {
+		t.Fatalf("Expected new credentials and token, got %s and %s", api.clientSecret, api.accessToken)
+	}
+	if _, err := api.GetLocations(); err != nil {
+		t.Fatalf("Expected new token to work, got err:\n%v", err)
+	}
+	if s := api.CredentialReloadStatus(); !s.LastSucceeded || s.Succeeded != 1 || s.Failed != 1 || s.LastSuccessAt.IsZero() {
+		t.Fatalf("Expected successful reload, got %+v", s)
+	}
+
+	// a transient read error is cleared by the next successful read of unchanged credentials
+	if err := os.Rename(secretFilePath, secretFilePath+".swap"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.ReloadCredentials(ctx); err == nil {
+		t.Fatalf("Expected reload of missing credentials to fail")
+	}
+	if s := api.CredentialReloadStatus(); s.LastSucceeded || s.Failed != 2 {
+		t.Fatalf("Expected failed read, got %+v", s)
+	}
+	if err := os.Rename(secretFilePath+".swap", secretFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := api.ReloadCredentials(ctx); changed || err != nil {
+		t.Fatalf("Expected unchanged credentials, got changed %v and err %v", changed, err)
+	}
+	if s := api.CredentialReloadStatus(); !s.LastSucceeded || s.Succeeded != 1 || s.Failed != 2 {
+		t.Fatalf("Expected successful check, got %+v", s)
+	}
+}
@@ -0,0 +1,188 @@
+package gardena
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// tokenRefreshMargin is the time before its expiry an access token is refreshed. Tokens living
+	// shorter than twice the margin are refreshed after half of their lifetime instead.
+	tokenRefreshMargin = time.Hour
+	// tokenMinRefreshWait is the minimum time between refreshes of a token in the background
+	tokenMinRefreshWait = 10 * time.Second
+	// tokenRetryInterval is the time between attempts to refresh a token in the background
+	tokenRetryInterval = time.Minute
+)
+
+// authenticate requests an access token from the configured authentication endpoint and stores it in the API
+func (api *API) authenticate() error {
+	return api.authenticateWithContext(context.Background())
+}
+
+// authenticateWithContext authenticates like authenticate, the given context is used to cancel the request.
+// The token is only requested if there is none yet or the current one is about to expire.
+func (api *API) authenticateWithContext(ctx context.Context) error {
+	api.refreshMu.Lock()
+	defer api.refreshMu.Unlock()
+	api.tokenMu.RLock()
+	due := api.refreshDue(time.Now())
+	api.tokenMu.RUnlock()
+	if !due {
+		// refreshed by another request in the meantime
+		return nil
+	}
+	return api.requestToken(ctx)
+}
+
+// refreshToken requests a new access token if the given token is still the current one, e.g.
+// because it was rejected by the api
+func (api *API) refreshToken(ctx context.Context, rejected string) error {
+	api.refreshMu.Lock()
+	defer api.refreshMu.Unlock()
+	api.tokenMu.RLock()
+	current := api.accessToken
+	api.tokenMu.RUnlock()
+	if current != rejected {
+		return nil
+	}
+	return api.requestToken(ctx)
+}
+
+// requestToken requests an access token from the configured authentication endpoint and stores it
+// in the API. The caller must hold refreshMu.
+func (api *API) requestToken(ctx context.Context) error {
+	auth, err := api.fetchToken(ctx, api.clientID, api.clientSecret)
+	if err != nil {
+		return err
+	}
+	api.tokenMu.Lock()
+	defer api.tokenMu.Unlock()
+	api.setToken(auth)
+	return nil
+}
+
+// fetchToken requests an access token for the given credentials from the configured authentication
+// endpoint without storing it
+func (api *API) fetchToken(ctx context.Context, clientID, clientSecret string) (*authResponse, error) {
+	if clientID == "" || clientSecret == "" {
+		return nil, fmt.Errorf("api not initialized, client-id or client-secret was empty")
+	}
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {clientID},
+		"client_secret": {clientSecret},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, api.authUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("unable to setup authentication request, got err %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := api.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to request access token, got err %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("authentication failed, got err: %w", newStatusError(res))
+	}
+	var auth authResponse
+	if err := decodeResponse(res, &auth); err != nil {
+		return nil, fmt.Errorf("unable to parse authentication response to json, err: %w", err)
+	}
+	return &auth, nil
+}
+
+// setToken stores the access token of the given authentication response. The caller must hold tokenMu.
+func (api *API) setToken(auth *authResponse) {
+	api.userID = auth.UserID
+	api.accessToken = auth.TokenType + " " + auth.AccessToken
+	api.tokenExpAt = time.Time{}
+	api.tokenTTL = 0
+	if auth.ExpiresIn > 0 {
+		api.tokenTTL = time.Second * time.Duration(auth.ExpiresIn)
+		api.tokenExpAt = time.Now().Add(api.tokenTTL)
+	}
+}
+
+// token returns the client id and access token for a request. If there is no token yet or the current
+// one is about to expire, a new token is requested first. If that fails, a token that is not yet expired
+// is still returned. An API without authentication url uses its access token as it is.
+func (api *API) token(ctx context.Context) (string, string, error) {
+	api.tokenMu.RLock()
+	clientID, token, expAt, due := api.clientID, api.accessToken, api.tokenExpAt, api.refreshDue(time.Now())
+	api.tokenMu.RUnlock()
+	if !due || api.authUrl == "" {
+		return clientID, token, nil
+	}
+	if err := api.authenticateWithContext(ctx); err != nil {
+		if token != "" && time.Now().Before(expAt) {
+			log.Printf("Unable to refresh access token, using current token valid until %v, got err:\n%v", expAt, err)
+			return clientID, token, nil
+		}
+		return "", "", err
+	}
+	api.tokenMu.RLock()
+	defer api.tokenMu.RUnlock()
+	return api.clientID, api.accessToken, nil
+}
+
+// refreshDue reports if the access token has to be requested or refreshed. The caller must hold tokenMu.
+func (api *API) refreshDue(now time.Time) bool {
+	return api.accessToken == "" || !api.tokenExpAt.IsZero() && now.After(api.refreshAt())
+}
+
+// refreshAt returns the time the access token is refreshed ahead of its expiry. The caller must hold tokenMu.
+func (api *API) refreshAt() time.Time {
+	margin := tokenRefreshMargin
+	if api.tokenTTL > 0 && api.tokenTTL/2 < margin {
+		margin = api.tokenTTL / 2
+	}
+	return api.tokenExpAt.Add(-margin)
+}
+
+// TokenExpiry returns the time the current access token expires, zero if there is no token
+// or it doesn't expire
+func (api *API) TokenExpiry() time.Time {
+	api.tokenMu.RLock()
+	defer api.tokenMu.RUnlock()
+	return api.tokenExpAt
+}
+
+// RunTokenRefresh refreshes the access token in the background ahead of its expiry, so requests
+// don't have to wait for a new token. Failed refreshes are retried. RunTokenRefresh blocks until
+// the given context is canceled.
+func (api *API) RunTokenRefresh(ctx context.Context) error {
+	for {
+		wait := tokenRetryInterval
+		api.tokenMu.RLock()
+		if !api.tokenExpAt.IsZero() {
+			wait = time.Until(api.refreshAt())
+		}
+		api.tokenMu.RUnlock()
+		if wait < tokenMinRefreshWait {
+			wait = tokenMinRefreshWait
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		if err := api.authenticateWithContext(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("Unable to refresh access token, retrying in %v, got err:\n%v", tokenRetryInterval, err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(tokenRetryInterval):
+			}
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package gardena
+
+import (
+	"context"
+	"fmt"
+	"github.com/Christoph-Raab/gardena-smart-system-exporter/pkg/gardena/gardenatest"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newAuthServer returns a server issuing the tokens token-1, token-2, ... valid for a day
+func newAuthServer(issued *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(issued, 1)
+		w.Write([]byte(fmt.Sprintf(`{"access_token": "token-%d", "token_type": "Bearer", "expires_in": 86400}`, n)))
+	}))
+}
+
+func TestTokenRefreshedAheadOfExpiry(t *testing.T) {
+	var issued int32
+	authServer := newAuthServer(&issued)
+	defer authServer.Close()
+
+	api := API{
+		authUrl:      authServer.URL,
+		httpClient:   &http.Client{},
+		clientID:     "abc",
+		clientSecret: "def",
+		accessToken:  "Bearer token-0",
+		tokenExpAt:   time.Now().Add(30 * time.Minute),
+	}
+	if err := api.authenticate(); err != nil {
+		t.Fatalf("Authentication failed with err:\n%v", err)
+	}
+	if api.accessToken != "Bearer token-1" {
+		t.Fatalf("Expected token expiring within %v to be refreshed, got %s", tokenRefreshMargin, api.accessToken)
+	}
+
+	// a token valid long enough is kept
+	if err := api.authenticate(); err != nil || issued != 1 {
+		t.Fatalf("Expected no further token to be requested, issued %d tokens, got err %v", issued, err)
+	}
+}
+
+func TestShortLivedToken(t *testing.T) {
+	cloud := gardenatest.NewServer()
+	defer cloud.Close()
+	if err := cloud.LoadFixture("../../test/location.json"); err != nil {
+		t.Fatalf("Unable to load fixture, got err:\n%v", err)
+	}
+	cloud.SetTokenTTL(10 * time.Minute)
+	secretFilePath := setupSecretFilesWithTmpDir("<some-client-id>", "<some-client-secret>")
+	defer os.RemoveAll(secretFilePath)
+	api, err := NewAPI().
+		WithBaseURL(cloud.APIURL()).
+		WithAuthURL(cloud.AuthURL()).
+		WithSecretFilePath(secretFilePath).
+		Initialize()
+	if err != nil {
+		t.Fatalf("Unable to initialize api, got err:\n%v", err)
+	}
+
+	// a token living shorter than the refresh margin is refreshed after half of its lifetime
+	if api.refreshDue(time.Now()) || !api.refreshDue(time.Now().Add(6*time.Minute)) {
+		t.Fatalf("Expected token valid until %v to be refreshed after 5 minutes", api.TokenExpiry())
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := api.GetLocations(); err != nil {
+			t.Fatalf("Unable to get locations, got err:\n%v", err)
+		}
+	}
+	if issued := cloud.TokensIssued(); issued != 1 {
+		t.Fatalf("Expected a single token for all requests, issued %d", issued)
+	}
+
+	// the background refresh waits between refreshes even if the token is due right away
+	cloud.SetTokenTTL(time.Second)
+	api.tokenMu.Lock()
+	api.accessToken = ""
+	api.tokenMu.Unlock()
+	if err := api.authenticate(); err != nil {
+		t.Fatalf("Authentication failed with err:\n%v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	api.RunTokenRefresh(ctx)
+	if issued := cloud.TokensIssued(); issued != 2 {
+		t.Fatalf("Expected no refresh within %v, issued %d tokens", tokenMinRefreshWait, issued)
+	}
+}
+
+func TestRequestRetriesWithNewTokenOnUnauthorized(t *testing.T) {
+	var issued int32
+	authServer := newAuthServer(&issued)
+	defer authServer.Close()
+	var tokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") != "Bearer token-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"data": []}`))
+	}))
+	defer server.Close()
+
+	api := API{
+		baseURL:      server.URL,
+		authUrl:      authServer.URL,
+		httpClient:   &http.Client{},
+		clientID:     "abc",
+		clientSecret: "def",
+		accessToken:  "Bearer revoked",
+		tokenExpAt:   time.Now().Add(24 * time.Hour),
+	}
+	if _, err := api.GetLocations(); err != nil {
+		t.Fatalf("Expected request to be retried with a new token, got err:\n%v", err)
+	}
+	if len(tokens) != 2 || tokens[0] != "Bearer revoked" || tokens[1] != "Bearer token-1" {
+		t.Fatalf("Expected a retry with the new token, got requests with %v", tokens)
+	}
+
+	// only retried once
+	api.accessToken = "Bearer revoked"
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	res, err := api.GetLocations()
+	if err == nil || issued != 2 {
+		t.Fatalf("Expected request to fail after one new token, issued %d tokens, got response %v", issued, res)
+	}
+}
+
+func TestTokenConcurrentRefresh(t *testing.T) {
+	var issued int32
+	authServer := newAuthServer(&issued)
+	defer authServer.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": []}`))
+	}))
+	defer server.Close()
+
+	api := &API{
+		baseURL:      server.URL,
+		authUrl:      authServer.URL,
+		httpClient:   &http.Client{},
+		clientID:     "abc",
+		clientSecret: "def",
+		accessToken:  "Bearer expired",
+		tokenExpAt:   time.Now().Add(-time.Hour),
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := api.GetLocations(); err != nil {
+				t.Errorf("Request failed with err:\n%v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if issued != 1 {
+		t.Fatalf("Expected a single token to be requested, got %d", issued)
+	}
+	if expAt := api.TokenExpiry(); expAt.Before(time.Now().Add(23 * time.Hour)) {
+		t.Fatalf("Expected token to expire in a day, got %v", expAt)
+	}
+}
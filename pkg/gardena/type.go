@@ -28,6 +28,10 @@ type Device struct {
 type Attribute struct {
 	Name  string
 	Value any
+	// Timestamp is the time the value was reported as RFC 3339 string. It's kept as string, so a
+	// malformed timestamp doesn't fail decoding the whole response but is reported as warning of
+	// its device.
+	Timestamp string
 }
 
 type State struct {
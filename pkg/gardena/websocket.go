@@ -0,0 +1,241 @@
+package gardena
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	typeWebsocket = "WEBSOCKET"
+
+	defaultPingInterval = 60 * time.Second
+	defaultMinBackoff   = time.Second
+	defaultMaxBackoff   = 5 * time.Minute
+)
+
+type websocketRequest struct {
+	Data struct {
+		Id         string `json:"id"`
+		Type       string `json:"type"`
+		Attributes struct {
+			LocationId string `json:"locationId"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+type websocketResponse struct {
+	Data struct {
+		Id         string `json:"id"`
+		Type       string `json:"type"`
+		Attributes struct {
+			Validity int    `json:"validity"`
+			Url      string `json:"url"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+// GetWebsocketURL requests a url of the realtime websocket for the given location.
+// The url is only valid for a short period of time and can only be used once.
+func (api *API) GetWebsocketURL(location Location) (string, error) {
+	return api.GetWebsocketURLWithContext(context.Background(), location)
+}
+
+// GetWebsocketURLWithContext requests a websocket url like GetWebsocketURL, the given context
+// is used to cancel the request
+func (api *API) GetWebsocketURLWithContext(ctx context.Context, location Location) (string, error) {
+	var r websocketRequest
+	r.Data.Id = "request-" + location.Id
+	r.Data.Type = typeWebsocket
+	r.Data.Attributes.LocationId = location.Id
+	body, err := json.Marshal(r)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal websocket request, got err:\n%w", err)
+	}
+
+	res, err := api.request(ctx, http.MethodPost, WebsocketURL, body)
+	if err != nil {
+		return "", fmt.Errorf("unable to request websocket for location %s, got err:\n%w", location.Id, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to request websocket for location %s, got err:\n%w", location.Id, newStatusError(res))
+	}
+
+	var ws websocketResponse
+	if err := decodeResponse(res, &ws); err != nil {
+		return "", fmt.Errorf("unmarshal of websocket response failed, got err:\n%w", err)
+	}
+	if ws.Data.Attributes.Url == "" {
+		return "", fmt.Errorf("websocket response for location %s contains no url", location.Id)
+	}
+	return ws.Data.Attributes.Url, nil
+}
+
+// Realtime receives the realtime updates of a location from the websocket api.
+// Each message is decoded to a Device, containing the changed attributes of a single service.
+type Realtime struct {
+	client   Client
+	location Location
+	dialer   *websocket.Dialer
+
+	pingInterval time.Duration
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+
+	onConnectionChange func(connected bool)
+}
+
+// NewRealtime creates a Realtime client for the given location
+func (api *API) NewRealtime(location Location) *Realtime {
+	return NewRealtime(api, location)
+}
+
+// NewRealtime creates a Realtime client for the given location, requesting websocket urls from the given Client
+func NewRealtime(client Client, location Location) *Realtime {
+	return &Realtime{
+		client:       client,
+		location:     location,
+		dialer:       websocket.DefaultDialer,
+		pingInterval: defaultPingInterval,
+		minBackoff:   defaultMinBackoff,
+		maxBackoff:   defaultMaxBackoff,
+	}
+}
+
+// OnConnectionChange sets a function that is called whenever the websocket connects or disconnects
+func (r *Realtime) OnConnectionChange(f func(connected bool)) *Realtime {
+	r.onConnectionChange = f
+	return r
+}
+
+// Run connects to the websocket of the location and calls handle for every received update.
+// If the connection fails or is closed, Run reconnects with an exponential backoff. Run blocks
+// until the given context is canceled.
+func (r *Realtime) Run(ctx context.Context, handle func(Device)) error {
+	backoff := Backoff{Min: r.minBackoff, Max: r.maxBackoff}
+	for {
+		received, err := r.listen(ctx, handle)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if received {
+			backoff.Reset()
+		}
+		wait := backoff.Next()
+		log.Printf("Websocket of location %s disconnected, reconnecting in %v, err was:\n%v", r.location.Id, wait, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// listen opens a single websocket connection and handles messages until the connection fails.
+// It reports whether at least one message was received.
+func (r *Realtime) listen(ctx context.Context, handle func(Device)) (bool, error) {
+	u, err := r.client.GetWebsocketURLWithContext(ctx, r.location)
+	if err != nil {
+		return false, err
+	}
+	conn, res, err := r.dialer.DialContext(ctx, u, nil)
+	if err != nil {
+		return false, fmt.Errorf("unable to connect to websocket, got err:\n%w", err)
+	}
+	res.Body.Close()
+	defer conn.Close()
+	r.setConnected(true)
+	defer r.setConnected(false)
+
+	done := make(chan struct{})
+	defer close(done)
+	go r.keepalive(ctx, conn, done)
+
+	readTimeout := 2 * r.pingInterval
+	conn.SetReadDeadline(time.Now().Add(readTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(readTimeout))
+	})
+
+	received := false
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return received, fmt.Errorf("unable to read from websocket, got err:\n%w", err)
+		}
+		received = true
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+
+		var d Device
+		if err := json.Unmarshal(msg, &d); err != nil {
+			log.Printf("Unable to decode websocket message '%s', got err:\n%v", msg, err)
+			continue
+		}
+		handle(d)
+	}
+}
+
+// keepalive pings the websocket server in the configured interval. It closes the connection
+// once the context is canceled or a ping fails, which also ends the read loop of listen.
+func (r *Realtime) keepalive(ctx context.Context, conn *websocket.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(r.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			conn.Close()
+			return
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				log.Printf("Unable to ping websocket of location %s, got err:\n%v", r.location.Id, err)
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (r *Realtime) setConnected(connected bool) {
+	if r.onConnectionChange != nil {
+		r.onConnectionChange(connected)
+	}
+}
+
+// Backoff is the exponential backoff websockets are reconnected with. It doubles from Min up to Max,
+// which default to one second and five minutes. The zero value is ready to use.
+type Backoff struct {
+	Min time.Duration
+	Max time.Duration
+
+	current time.Duration
+}
+
+// Next returns the time to wait before the next attempt, a random duration between half and all
+// of the current backoff, and doubles the backoff
+func (b *Backoff) Next() time.Duration {
+	min, max := b.Min, b.Max
+	if min <= 0 {
+		min = defaultMinBackoff
+	}
+	if max <= 0 {
+		max = defaultMaxBackoff
+	}
+	if b.current < min {
+		b.current = min
+	}
+	wait := jitter(b.current)
+	b.current = minDuration(2*b.current, max)
+	return wait
+}
+
+// Reset resets the backoff to Min, e.g. after a successful attempt
+func (b *Backoff) Reset() {
+	b.current = 0
+}
@@ -0,0 +1,80 @@
+package gardena
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRealtimeReconnectsAndHandlesUpdates(t *testing.T) {
+	var connections int32
+	upgrader := websocket.Upgrader{}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case WebsocketURL:
+			var req websocketRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Errorf("Unable to decode websocket request, got err:\n%v", err)
+			}
+			if req.Data.Attributes.LocationId != "location-1-id" {
+				t.Errorf("Expected websocket request for location-1-id, got %s", req.Data.Attributes.LocationId)
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"data": {"type": "WEBSOCKET", "attributes": {"validity": 10, "url": "` +
+				"ws" + strings.TrimPrefix(server.URL, "http") + `/socket"}}}`))
+		case "/socket":
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				t.Errorf("Unable to upgrade connection, got err:\n%v", err)
+				return
+			}
+			defer conn.Close()
+			n := atomic.AddInt32(&connections, 1)
+			// Each connection sends one update and is closed afterwards to force a reconnect
+			conn.WriteMessage(websocket.TextMessage, []byte(`{
+				"id": "dev-1-id",
+				"type": "SENSOR",
+				"attributes": {"soilHumidity": {"value": `+string(rune('0'+n))+`}}
+			}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	api := API{baseURL: server.URL, httpClient: &http.Client{}}
+	rt := api.NewRealtime(Location{Id: "location-1-id"})
+	rt.minBackoff = time.Millisecond
+	rt.maxBackoff = 10 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var updates []Device
+	err := rt.Run(ctx, func(d Device) {
+		updates = append(updates, d)
+		if len(updates) == 2 {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Fatalf("Expected run to stop with context.Canceled, got %v", err)
+	}
+	if n := atomic.LoadInt32(&connections); n < 2 {
+		t.Fatalf("Expected at least two connections, got %d", n)
+	}
+	for i, u := range updates {
+		if u.Id != "dev-1-id" || u.Type != "SENSOR" {
+			t.Fatalf("Unexpected update %v", u)
+		}
+		if v := u.Attributes["soilHumidity"].Value; v != float64(i+1) {
+			t.Fatalf("Expected soil humidity of update %d to be %d, got %v", i, i+1, v)
+		}
+	}
+}